@@ -2,28 +2,96 @@ package config
 
 type Config struct {
 	Server   Server
+	Store    Store
 	Github   Github
 	CircleCi CircleCi
+	Jenkins  Jenkins
 	Monitor  Monitor
+	Delivery Delivery
 }
 
 type Server struct {
 	Port string `env:"PORT" envDefault:"8080"`
+	// AdminToken guards the /admin endpoints. Admin endpoints are disabled when it is empty.
+	AdminToken string `env:"ADMIN_TOKEN"`
+	// ConfigWatchIntervalS defines how often config files are checked for changes.
+	ConfigWatchIntervalS int `env:"CONFIG_WATCH_INTERVAL_SECONDS" envDefault:"5"`
+}
+
+type Store struct {
+	Dir string `env:"STORE_DIR" envDefault:"./data"`
 }
 
 type Github struct {
 	Key string `env:"GITHUB_KEY"`
 	Org string `env:"GITHUB_ORG"`
+	// WebhookSecrets lists every secret accepted for webhook signatures, comma separated.
+	// Keep the old and the new one here while rotating.
+	WebhookSecrets []string `env:"GITHUB_WEBHOOK_SECRETS"`
+	// DeliveryTtlH defines how long delivery ids are kept to drop redelivered webhooks.
+	DeliveryTtlH int `env:"GITHUB_DELIVERY_TTL_HOURS" envDefault:"72"`
 }
 
 type CircleCi struct {
 	Key string `env:"CIRCLE_CI_KEY"`
 	Org string `env:"CIRCLE_CI_ORG"`
+	// WebhookSecrets lists every secret accepted for circleci webhook signatures, comma separated.
+	WebhookSecrets []string `env:"CIRCLE_CI_WEBHOOK_SECRETS"`
+	// ApiVersion picks between v1 recent builds, one build per job, and v2 pipelines, one build per workflow.
+	ApiVersion int `env:"CIRCLE_CI_API_VERSION" envDefault:"1"`
+	// MaxPages bounds how deep the v1 lookup pages back, 100 builds a page.
+	MaxPages int `env:"CIRCLE_CI_MAX_PAGES" envDefault:"10"`
+}
+
+type Jenkins struct {
+	// Url enables the jenkins provider.
+	Url   string `env:"JENKINS_URL"`
+	User  string `env:"JENKINS_USER"`
+	Token string `env:"JENKINS_TOKEN"`
+	// JobPath locates the job of a repo and branch, {org}, {repo} and {branch} get replaced.
+	// The default fits multibranch pipelines, where a tag build lives under the tag name.
+	JobPath string `env:"JENKINS_JOB_PATH" envDefault:"{repo}/{branch}"`
 }
 
 type Monitor struct {
 	PollTimeIntervalS int `env:"POLL_TIME_INTERVAL_SECONDS" envDefault:"10"`
-	// PollForBuildsTimes defines how many times search for for build in the Ci response.
-	PollForBuildsTimes      int `env:"POLL_FOR_BUILDS_TIMES" envDefault:"3"`
-	PollForGreenBuildsTimes int `env:"POLL_FOR_GREEN_BUILDS_TIMES" envDefault:"20"`
+	// PollMaxIntervalS caps the poll interval as it backs off.
+	PollMaxIntervalS int `env:"POLL_MAX_INTERVAL_SECONDS" envDefault:"60"`
+	// PollBackoffPercent grows the interval after every poll which did not settle the build, 150 makes it 1.5 times longer.
+	PollBackoffPercent int `env:"POLL_BACKOFF_PERCENT" envDefault:"150"`
+	PollJitterPercent  int `env:"POLL_JITTER_PERCENT" envDefault:"20"`
+	// BuildAppearTimeoutS defines how long to wait for the build to show up in the ci.
+	BuildAppearTimeoutS int `env:"BUILD_APPEAR_TIMEOUT_SECONDS" envDefault:"300"`
+	// GreenTimeoutS defines how long to wait for the found builds to go green.
+	GreenTimeoutS int `env:"GREEN_TIMEOUT_SECONDS" envDefault:"3600"`
+	// WebhookFallbackS defines how long a monitor relies on circleci webhooks alone before it starts polling.
+	// Zero polls from the start.
+	WebhookFallbackS int `env:"WEBHOOK_FALLBACK_SECONDS" envDefault:"0"`
+	// Provider names the ci which builds the repos without an override.
+	Provider string `env:"CI_PROVIDER" envDefault:"circleci"`
+	// ProgressNotifications sends started and progress notifications for every repo, not only the ones opted in.
+	ProgressNotifications bool `env:"PROGRESS_NOTIFICATIONS" envDefault:"false"`
+	// FailedTestsMax, LogTailLines and LogTailBytes limit the failed tests and the output
+	// of the failed step put into build_failed notifications. Zero leaves them out.
+	FailedTestsMax int `env:"FAILED_TESTS_MAX" envDefault:"10"`
+	LogTailLines   int `env:"LOG_TAIL_LINES" envDefault:"20"`
+	LogTailBytes   int `env:"LOG_TAIL_BYTES" envDefault:"2000"`
+	// BuildRetries defines how many times failed builds get retried before the failure is reported.
+	BuildRetries int `env:"BUILD_RETRIES" envDefault:"0"`
+	// PollForBuildsTimes and PollForGreenBuildsTimes are the poll counts the timeouts replaced.
+	// When still set they are turned into the timeouts at startup.
+	PollForBuildsTimes      int `env:"POLL_FOR_BUILDS_TIMES"`
+	PollForGreenBuildsTimes int `env:"POLL_FOR_GREEN_BUILDS_TIMES"`
+	// PollShareWindowMs defines how long a fetched list of builds is shared between monitors of the project.
+	// The polls start at the window boundaries, so that the monitors of the project meet in one window.
+	PollShareWindowMs int `env:"POLL_SHARE_WINDOW_MS" envDefault:"8000"`
+}
+
+type Delivery struct {
+	Workers   int `env:"DELIVERY_WORKERS" envDefault:"2"`
+	QueueSize int `env:"DELIVERY_QUEUE_SIZE" envDefault:"100"`
+	// MaxAttempts defines how many times a message is sent before it goes to the dead letters.
+	MaxAttempts   int `env:"DELIVERY_MAX_ATTEMPTS" envDefault:"6"`
+	BackoffBaseMs int `env:"DELIVERY_BACKOFF_BASE_MS" envDefault:"1000"`
+	BackoffMaxMs  int `env:"DELIVERY_BACKOFF_MAX_MS" envDefault:"60000"`
 }
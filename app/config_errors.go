@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConfigError points to the place in a config file which is wrong.
+type ConfigError struct {
+	File string
+	Path string
+	Err  error
+}
+
+func (e ConfigError) Error() string {
+	if len(e.Path) == 0 {
+		return e.File + ": " + e.Err.Error()
+	}
+
+	return e.File + ": " + e.Path + ": " + e.Err.Error()
+}
+
+// ConfigErrors collects every problem found in the config files instead of stopping at the first one.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, ce := range e {
+		lines = append(lines, ce.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (e *ConfigErrors) add(file, path string, err error) {
+	*e = append(*e, ConfigError{File: file, Path: path, Err: err})
+}
+
+func (e ConfigErrors) errOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+func keyPath(path, key string) string {
+	return fmt.Sprintf("%s[%q]", path, key)
+}
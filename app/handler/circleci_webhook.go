@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/httputil"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/logging"
+	"github.com/kudrykv/services-deploy-monitor/app/service"
+	"net/http"
+)
+
+type CircleCiWebhook interface {
+	Handle(w http.ResponseWriter, r *http.Request)
+}
+
+type circleCiWebhook struct {
+	cs service.CircleCiHooks
+	ds service.Deliveries
+	cm service.CiMonitor
+}
+
+func NewCircleCiWebhook(cs service.CircleCiHooks, ds service.Deliveries, cm service.CiMonitor) CircleCiWebhook {
+	return &circleCiWebhook{
+		cs: cs,
+		ds: ds,
+		cm: cm,
+	}
+}
+
+func (h circleCiWebhook) Handle(w http.ResponseWriter, r *http.Request) {
+	fields := logrus.Fields{
+		"request_id": httputil.GetRequestId(r.Context()),
+		"event":      r.Header.Get("Circleci-Event-Type"),
+	}
+
+	bytes, err := httputil.ReadBytes(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.cs.VerifySignature(r.Header.Get("Circleci-Signature"), bytes); err != nil {
+		logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Warn("reject webhook")
+		httputil.Json(r.Context(), w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	result, err := h.cs.ParseWebhook(bytes)
+	if err != nil {
+		httputil.Json(r.Context(), w, http.StatusOK, err.Error())
+		return
+	}
+
+	fields["delivery"] = result.Id
+
+	// delivery ids of both webhooks share the store
+	delivery := ""
+	if len(result.Id) > 0 {
+		delivery = "circleci:" + result.Id
+	}
+
+	seen, err := h.ds.Seen(delivery)
+	if err != nil {
+		logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Error("record delivery")
+	}
+
+	if seen {
+		logging.WithFields(fields).Info("drop duplicate delivery")
+		httputil.Json(r.Context(), w, http.StatusOK, "duplicate delivery")
+		return
+	}
+
+	taken := h.cm.Deliver(*result)
+	logging.WithFields(fields).WithFields(logrus.Fields{
+		"repo":     result.Repo,
+		"sha":      result.Sha,
+		"status":   result.Status,
+		"monitors": taken,
+	}).Info("ci result delivered")
+
+	httputil.Json(r.Context(), w, http.StatusOK, "OK")
+}
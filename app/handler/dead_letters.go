@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"github.com/kudrykv/services-deploy-monitor/app/internal/httputil"
+	"github.com/kudrykv/services-deploy-monitor/app/service"
+	"goji.io/pat"
+	"net/http"
+)
+
+type DeadLetters interface {
+	List(w http.ResponseWriter, r *http.Request)
+	Resend(w http.ResponseWriter, r *http.Request)
+}
+
+type deadLetters struct {
+	queue service.DeliveryQueue
+}
+
+func NewDeadLetters(queue service.DeliveryQueue) DeadLetters {
+	return &deadLetters{
+		queue: queue,
+	}
+}
+
+func (h deadLetters) List(w http.ResponseWriter, r *http.Request) {
+	letters, err := h.queue.DeadLetters()
+	if err != nil {
+		httputil.Json(r.Context(), w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httputil.Json(r.Context(), w, http.StatusOK, letters)
+}
+
+func (h deadLetters) Resend(w http.ResponseWriter, r *http.Request) {
+	err := h.queue.Resend(r.Context(), pat.Param(r, "id"))
+	if err == service.ErrDeadLetterNotFound {
+		httputil.Json(r.Context(), w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err != nil {
+		httputil.Json(r.Context(), w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httputil.Json(r.Context(), w, http.StatusAccepted, "OK")
+}
@@ -2,7 +2,9 @@ package handler
 
 import (
 	"context"
+	"github.com/Sirupsen/logrus"
 	"github.com/kudrykv/services-deploy-monitor/app/internal/httputil"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/logging"
 	"github.com/kudrykv/services-deploy-monitor/app/service"
 	"net/http"
 )
@@ -13,13 +15,15 @@ type GithubWebhook interface {
 
 type githubWebhook struct {
 	gs service.GhWrap
+	ds service.Deliveries
 	cm service.CiMonitor
 	ns service.Notifier
 }
 
-func NewGithubWebhook(gs service.GhWrap, cm service.CiMonitor, ns service.Notifier) GithubWebhook {
+func NewGithubWebhook(gs service.GhWrap, ds service.Deliveries, cm service.CiMonitor, ns service.Notifier) GithubWebhook {
 	return &githubWebhook{
 		gs: gs,
+		ds: ds,
 		cm: cm,
 		ns: ns,
 	}
@@ -27,10 +31,11 @@ func NewGithubWebhook(gs service.GhWrap, cm service.CiMonitor, ns service.Notifi
 
 func (h githubWebhook) HandlePullRequest(w http.ResponseWriter, r *http.Request) {
 	event := r.Header.Get("X-GitHub-Event")
-	if !h.gs.IsEventSupported(event) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("unsupported event"))
-		return
+	delivery := r.Header.Get("X-GitHub-Delivery")
+	fields := logrus.Fields{
+		"request_id": httputil.GetRequestId(r.Context()),
+		"event":      event,
+		"delivery":   delivery,
 	}
 
 	bytes, err := httputil.ReadBytes(r)
@@ -39,6 +44,18 @@ func (h githubWebhook) HandlePullRequest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := h.gs.VerifySignature(r.Header.Get("X-Hub-Signature-256"), bytes); err != nil {
+		logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Warn("reject webhook")
+		httputil.Json(r.Context(), w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if !h.gs.IsEventSupported(event) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("unsupported event"))
+		return
+	}
+
 	hook, err := h.gs.ParseWebhook(r.Context(), event, bytes)
 	if err != nil {
 		w.WriteHeader(http.StatusOK)
@@ -46,6 +63,18 @@ func (h githubWebhook) HandlePullRequest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	seen, err := h.ds.Seen(delivery)
+	if err != nil {
+		// better to notify twice than to lose the delivery
+		logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Error("record delivery")
+	}
+
+	if seen {
+		logging.WithFields(fields).Info("drop duplicate delivery")
+		httputil.Json(r.Context(), w, http.StatusOK, "duplicate delivery")
+		return
+	}
+
 	httputil.Json(r.Context(), w, http.StatusOK, "OK")
 
 	ctx := httputil.AddCustomRequestId(context.Background(), httputil.GetRequestId(r.Context()))
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/kudrykv/services-deploy-monitor/app/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGithubWebhookRejectsUnsigned(t *testing.T) {
+	const body = `{"zen":"Keep it logically awesome."}`
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	valid := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		signature string
+		code      int
+		response  string
+	}{
+		{name: "valid", signature: valid, code: http.StatusOK, response: "unsupported event"},
+		{name: "wrong", signature: "sha256=" + strings.Repeat("0", 64), code: http.StatusUnauthorized, response: service.ErrSignatureMismatch.Error()},
+		{name: "missing", code: http.StatusUnauthorized, response: service.ErrMissingSignature.Error()},
+		{name: "malformed", signature: "sha1=abc", code: http.StatusUnauthorized, response: service.ErrMalformedSignature.Error()},
+	}
+
+	h := NewGithubWebhook(service.NewGithub("", "org", []string{"secret"}), nil, nil, nil)
+
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/webhook/github", strings.NewReader(body))
+		r.Header.Set("X-GitHub-Event", "ping")
+		if len(test.signature) > 0 {
+			r.Header.Set("X-Hub-Signature-256", test.signature)
+		}
+
+		w := httptest.NewRecorder()
+		h.HandlePullRequest(w, r)
+
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.response) {
+			t.Errorf("%s: expected %d %q, got %d %q", test.name, test.code, test.response, w.Code, w.Body.String())
+		}
+	}
+}
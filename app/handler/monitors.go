@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"github.com/kudrykv/services-deploy-monitor/app/internal/httputil"
+	"github.com/kudrykv/services-deploy-monitor/app/service"
+	"goji.io/pat"
+	"net/http"
+	"strconv"
+)
+
+type Monitors interface {
+	List(w http.ResponseWriter, r *http.Request)
+	Get(w http.ResponseWriter, r *http.Request)
+	Cancel(w http.ResponseWriter, r *http.Request)
+}
+
+type monitors struct {
+	cm service.CiMonitor
+}
+
+func NewMonitors(cm service.CiMonitor) Monitors {
+	return &monitors{
+		cm: cm,
+	}
+}
+
+func (h monitors) List(w http.ResponseWriter, r *http.Request) {
+	httputil.Json(r.Context(), w, http.StatusOK, h.cm.List())
+}
+
+func (h monitors) Get(w http.ResponseWriter, r *http.Request) {
+	state, ok := h.cm.Get(pat.Param(r, "id"))
+	if !ok {
+		httputil.Json(r.Context(), w, http.StatusNotFound, service.ErrMonitorNotFound.Error())
+		return
+	}
+
+	httputil.Json(r.Context(), w, http.StatusOK, state)
+}
+
+func (h monitors) Cancel(w http.ResponseWriter, r *http.Request) {
+	notify, _ := strconv.ParseBool(r.URL.Query().Get("notify"))
+
+	err := h.cm.Cancel(pat.Param(r, "id"), notify)
+	if err == service.ErrMonitorNotFound {
+		httputil.Json(r.Context(), w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err != nil {
+		httputil.Json(r.Context(), w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httputil.Json(r.Context(), w, http.StatusAccepted, "OK")
+}
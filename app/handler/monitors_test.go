@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/kudrykv/services-deploy-monitor/app/service"
+	"goji.io"
+	"goji.io/pat"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeMonitors struct {
+	service.CiMonitor
+
+	states   map[string]service.MonitorState
+	canceled map[string]bool
+}
+
+func (m *fakeMonitors) List() []service.MonitorState {
+	return []service.MonitorState{m.states["a"]}
+}
+
+func (m *fakeMonitors) Get(id string) (service.MonitorState, bool) {
+	state, ok := m.states[id]
+	return state, ok
+}
+
+func (m *fakeMonitors) Cancel(id string, notify bool) error {
+	if _, ok := m.states[id]; !ok {
+		return service.ErrMonitorNotFound
+	}
+
+	m.canceled[id] = notify
+
+	return nil
+}
+
+func TestMonitorsRoutes(t *testing.T) {
+	cm := &fakeMonitors{
+		states:   map[string]service.MonitorState{"a": {Id: "a"}},
+		canceled: map[string]bool{},
+	}
+
+	h := NewMonitors(cm)
+	mux := goji.NewMux()
+	mux.HandleFunc(pat.Get("/monitors"), h.List)
+	mux.HandleFunc(pat.Get("/monitors/:id"), h.Get)
+	mux.HandleFunc(pat.Delete("/monitors/:id"), h.Cancel)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{method: "GET", path: "/monitors", code: http.StatusOK},
+		{method: "GET", path: "/monitors/a", code: http.StatusOK},
+		{method: "GET", path: "/monitors/b", code: http.StatusNotFound},
+		{method: "DELETE", path: "/monitors/b", code: http.StatusNotFound},
+		{method: "DELETE", path: "/monitors/a?notify=true", code: http.StatusAccepted},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected %d, got %d %q", test.method, test.path, test.code, w.Code, w.Body.String())
+		}
+	}
+
+	if notify, ok := cm.canceled["a"]; !ok || !notify {
+		t.Errorf("expected a to be canceled with notify, got %v %v", notify, ok)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/monitors", nil))
+
+	var states []service.MonitorState
+	if err := json.Unmarshal(w.Body.Bytes(), &states); err != nil || len(states) != 1 || states[0].Id != "a" {
+		t.Errorf("expected the listed monitor, got %v %v", states, err)
+	}
+}
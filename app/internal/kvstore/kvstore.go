@@ -0,0 +1,183 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type item struct {
+	Value     json.RawMessage `json:"value"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+}
+
+// Store is a small embedded key-value store. The whole content lives in memory
+// and is flushed to a single json file on every write.
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]item
+}
+
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		items: map[string]item{},
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	bts, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bts) > 0 {
+		if err := json.Unmarshal(bts, &s.items); err != nil {
+			return nil, err
+		}
+	}
+
+	s.purge(time.Now())
+
+	return s, nil
+}
+
+func (s *Store) Get(key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	it, ok := s.items[key]
+	if !ok || it.expired(time.Now()) {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(it.Value, v)
+}
+
+// Put stores the value under the key. Zero ttl keeps the value until it gets deleted.
+func (s *Store) Put(key string, v interface{}, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(key, v, ttl)
+}
+
+// PutIfAbsent stores the value only if the key is missing or expired, and reports whether it did.
+func (s *Store) PutIfAbsent(key string, v interface{}, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if it, ok := s.items[key]; ok && !it.expired(time.Now()) {
+		return false, nil
+	}
+
+	return true, s.put(key, v, ttl)
+}
+
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[key]; !ok {
+		return nil
+	}
+
+	delete(s.items, key)
+
+	return s.flush()
+}
+
+// Each walks over live entries in key order.
+func (s *Store) Each(f func(key string, value json.RawMessage) error) error {
+	s.mu.Lock()
+	now := time.Now()
+	keys := make([]string, 0, len(s.items))
+	values := map[string]json.RawMessage{}
+	for key, it := range s.items {
+		if !it.expired(now) {
+			keys = append(keys, key)
+			values[key] = it.Value
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := f(key, values[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) put(key string, v interface{}, ttl time.Duration) error {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	it := item{Value: bts}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		it.ExpiresAt = &expiresAt
+	}
+
+	s.items[key] = it
+	s.purge(time.Now())
+
+	return s.flush()
+}
+
+func (s *Store) purge(now time.Time) {
+	for key, it := range s.items {
+		if it.expired(now) {
+			delete(s.items, key)
+		}
+	}
+}
+
+func (s *Store) flush() error {
+	bts, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+
+	// write aside and rename, so that a crash leaves either the old or the new file
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(bts); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+func (it item) expired(now time.Time) bool {
+	return it.ExpiresAt != nil && now.After(*it.ExpiresAt)
+}
@@ -0,0 +1,121 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTemp(t *testing.T) (*Store, string, func()) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "nested", "store.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, path, func() { os.RemoveAll(dir) }
+}
+
+func TestStorePersists(t *testing.T) {
+	s, path, cleanup := openTemp(t)
+	defer cleanup()
+
+	if err := s.Put("b", map[string]int{"n": 2}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put("a", map[string]int{"n": 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put("gone", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete("gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected no temp file left, got %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v map[string]int
+	if ok, err := reopened.Get("b", &v); !ok || err != nil || v["n"] != 2 {
+		t.Errorf("expected b to survive, got %v %v %v", ok, err, v)
+	}
+
+	if ok, _ := reopened.Get("gone", &v); ok {
+		t.Error("expected the deleted key to stay deleted")
+	}
+
+	var keys []string
+	reopened.Each(func(key string, value json.RawMessage) error {
+		keys = append(keys, key)
+		return nil
+	})
+
+	if strings.Join(keys, ",") != "a,b" {
+		t.Errorf("expected keys in order, got %v", keys)
+	}
+}
+
+func TestStoreExpires(t *testing.T) {
+	s, path, cleanup := openTemp(t)
+	defer cleanup()
+
+	if stored, err := s.PutIfAbsent("k", 1, 20*time.Millisecond); !stored || err != nil {
+		t.Fatalf("expected the first put to store, got %v %v", stored, err)
+	}
+
+	if stored, _ := s.PutIfAbsent("k", 2, 20*time.Millisecond); stored {
+		t.Error("expected the second put to find the key")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	var v int
+	if ok, _ := s.Get("k", &v); ok {
+		t.Error("expected the key to expire")
+	}
+
+	if stored, _ := s.PutIfAbsent("k", 3, 0); !stored {
+		t.Error("expected the put to replace the expired key")
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := reopened.Get("k", &v); !ok || v != 3 {
+		t.Errorf("expected 3, got %v %d", ok, v)
+	}
+}
+
+func TestOpenRejectsBrokenFile(t *testing.T) {
+	_, path, cleanup := openTemp(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(path); err == nil {
+		t.Error("expected an error for a broken file")
+	}
+}
@@ -1,38 +1,120 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"expvar"
+	"github.com/Sirupsen/logrus"
 	"github.com/caarlos0/env"
 	"github.com/kudrykv/services-deploy-monitor/app/config"
 	"github.com/kudrykv/services-deploy-monitor/app/handler"
 	"github.com/kudrykv/services-deploy-monitor/app/internal/httputil"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/kvstore"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/logging"
 	"github.com/kudrykv/services-deploy-monitor/app/service"
 	"goji.io"
 	"goji.io/pat"
 	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:], os.Stdout))
+	}
+
+	loaded, err := loadConfig(patternsPath, slackPath)
+	if err != nil {
+		logging.WithFields(logrus.Fields{"err": err.Error()}).Error("load config")
+		os.Exit(1)
+	}
+
 	cfg := config.Config{}
 	env.Parse(&cfg.Server)
+	env.Parse(&cfg.Store)
 	env.Parse(&cfg.Github)
 	env.Parse(&cfg.CircleCi)
+	env.Parse(&cfg.Jenkins)
 	env.Parse(&cfg.Monitor)
+	cfg.Monitor = migrateMonitor(cfg.Monitor, envSet)
+	env.Parse(&cfg.Delivery)
+
+	deliveriesStore, err := kvstore.Open(filepath.Join(cfg.Store.Dir, "deliveries.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	deadLettersStore, err := kvstore.Open(filepath.Join(cfg.Store.Dir, "dead-letters.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	monitorsStore, err := kvstore.Open(filepath.Join(cfg.Store.Dir, "monitors.json"))
+	if err != nil {
+		panic(err)
+	}
 
-	githubService := service.NewGithub(cfg.Github.Key, cfg.Github.Org)
+	githubService := service.NewGithub(cfg.Github.Key, cfg.Github.Org, cfg.Github.WebhookSecrets)
 	changelogService := service.NewChangelog(githubService)
-	circleCiService := service.NewCircleCi(cfg.CircleCi.Key)
-	ciMonitorService := service.NewCiMonitor(cfg.Monitor, githubService, circleCiService)
+	deliveriesService := service.NewDeliveries(deliveriesStore, time.Duration(cfg.Github.DeliveryTtlH)*time.Hour)
+	circleCiService := service.NewCircleCiPoller(
+		service.NewCircleCi(cfg.CircleCi.Key),
+		time.Duration(cfg.Monitor.PollShareWindowMs)*time.Millisecond,
+	)
+	ciProviders := []service.CiProvider{
+		service.NewGithubActions(githubService),
+	}
 
-	notifierService := service.New(ParseConfig("./send-patterns.json", ParseSlack("./slack-config.json")))
+	if cfg.CircleCi.ApiVersion == 2 {
+		ciProviders = append(ciProviders, service.NewCircleCiV2(cfg.CircleCi.Key, time.Duration(cfg.Monitor.PollShareWindowMs)*time.Millisecond))
+	} else {
+		ciProviders = append(ciProviders, service.NewCircleCiProvider(circleCiService, cfg.CircleCi.MaxPages))
+	}
+
+	if len(cfg.Jenkins.Url) > 0 {
+		ciProviders = append(ciProviders, service.NewJenkins(cfg.Jenkins.Url, cfg.Jenkins.User, cfg.Jenkins.Token, cfg.Jenkins.JobPath))
+	}
+	circleCiHooksService := service.NewCircleCiWebhook(cfg.CircleCi.WebhookSecrets)
+	ciMonitorService := service.NewCiMonitor(cfg.Monitor, loaded.Monitors, githubService, ciProviders, monitorsStore)
+
+	routing := service.NewRouting(loaded)
+	deliveryQueue := service.NewDeliveryQueue(cfg.Delivery, routing, deadLettersStore)
+	notifierService := service.New(routing, deliveryQueue)
+
+	deliveryQueue.Start(context.Background())
+	ciMonitorService.Resume(context.Background(), notifierService.Do)
+
+	go watchConfig(context.Background(), time.Duration(cfg.Server.ConfigWatchIntervalS)*time.Second, patternsPath, slackPath, func(lc service.Config) {
+		routing.Set(lc)
+		ciMonitorService.SetOverrides(lc.Monitors)
+	})
 
 	changelogHandler := handler.NewChangelog(changelogService)
-	githubWebhookHandler := handler.NewGithubWebhook(githubService, ciMonitorService, notifierService)
+	githubWebhookHandler := handler.NewGithubWebhook(githubService, deliveriesService, ciMonitorService, notifierService)
+	circleCiWebhookHandler := handler.NewCircleCiWebhook(circleCiHooksService, deliveriesService, ciMonitorService)
+	deadLettersHandler := handler.NewDeadLetters(deliveryQueue)
+	monitorsHandler := handler.NewMonitors(ciMonitorService)
 
 	mux := goji.NewMux()
 	mux.Use(trackDecorator)
 
 	mux.HandleFunc(pat.Get("/changelog/:repo"), changelogHandler.Build)
 	mux.HandleFunc(pat.Post("/webhook/github"), githubWebhookHandler.HandlePullRequest)
+	mux.HandleFunc(pat.Post("/webhook/circleci"), circleCiWebhookHandler.Handle)
+
+	admin := goji.SubMux()
+	admin.Use(adminDecorator(cfg.Server.AdminToken))
+	mux.Handle(pat.New("/admin/*"), admin)
+
+	admin.Handle(pat.Get("/debug/vars"), expvar.Handler())
+	admin.HandleFunc(pat.Get("/dead-letters"), deadLettersHandler.List)
+	admin.HandleFunc(pat.Post("/dead-letters/:id/resend"), deadLettersHandler.Resend)
+
+	admin.HandleFunc(pat.Get("/monitors"), monitorsHandler.List)
+	admin.HandleFunc(pat.Get("/monitors/:id"), monitorsHandler.Get)
+	admin.HandleFunc(pat.Delete("/monitors/:id"), monitorsHandler.Cancel)
 
 	http.ListenAndServe(":"+cfg.Server.Port, mux)
 }
@@ -44,3 +126,19 @@ func trackDecorator(hf http.Handler) http.Handler {
 		hf.ServeHTTP(w, r)
 	})
 }
+
+func adminDecorator(token string) func(http.Handler) http.Handler {
+	return func(hf http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := []byte(r.Header.Get("Authorization"))
+			expected := []byte("Bearer " + token)
+
+			if len(token) == 0 || subtle.ConstantTimeCompare(given, expected) != 1 {
+				httputil.Json(r.Context(), w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+
+			hf.ServeHTTP(w, r)
+		})
+	}
+}
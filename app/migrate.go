@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/kudrykv/services-deploy-monitor/app/config"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/logging"
+	"os"
+)
+
+// migrateMonitor turns the poll counts of the old env into the timeouts which replaced them,
+// unless the timeouts are set too.
+func migrateMonitor(cm config.Monitor, set func(key string) bool) config.Monitor {
+	old := []struct {
+		key     string
+		times   int
+		timeout string
+		seconds *int
+	}{
+		{"POLL_FOR_BUILDS_TIMES", cm.PollForBuildsTimes, "BUILD_APPEAR_TIMEOUT_SECONDS", &cm.BuildAppearTimeoutS},
+		{"POLL_FOR_GREEN_BUILDS_TIMES", cm.PollForGreenBuildsTimes, "GREEN_TIMEOUT_SECONDS", &cm.GreenTimeoutS},
+	}
+
+	for _, o := range old {
+		if o.times <= 0 {
+			continue
+		}
+
+		fields := logrus.Fields{"deprecated": o.key, "use": o.timeout}
+		if set(o.timeout) {
+			logging.WithFields(fields).Warn("deprecated env is ignored")
+			continue
+		}
+
+		*o.seconds = o.times * cm.PollTimeIntervalS
+		logging.WithFields(fields).WithFields(logrus.Fields{"seconds": *o.seconds}).Warn("deprecated env is turned into a timeout")
+	}
+
+	return cm
+}
+
+func envSet(key string) bool {
+	_, ok := os.LookupEnv(key)
+	return ok
+}
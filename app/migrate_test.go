@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/kudrykv/services-deploy-monitor/app/config"
+	"testing"
+)
+
+func TestMigrateMonitor(t *testing.T) {
+	defaults := config.Monitor{PollTimeIntervalS: 10, BuildAppearTimeoutS: 300, GreenTimeoutS: 3600}
+
+	tests := []struct {
+		name   string
+		builds int
+		green  int
+		set    []string
+		appear int
+		wait   int
+	}{
+		{name: "new env", appear: 300, wait: 3600},
+		{name: "old env", builds: 3, green: 20, appear: 30, wait: 200},
+		{name: "both", builds: 3, green: 20, set: []string{"GREEN_TIMEOUT_SECONDS"}, appear: 30, wait: 3600},
+	}
+
+	for _, test := range tests {
+		cm := defaults
+		cm.PollForBuildsTimes = test.builds
+		cm.PollForGreenBuildsTimes = test.green
+
+		cm = migrateMonitor(cm, func(key string) bool {
+			for _, set := range test.set {
+				if set == key {
+					return true
+				}
+			}
+
+			return false
+		})
+
+		if cm.BuildAppearTimeoutS != test.appear || cm.GreenTimeoutS != test.wait {
+			t.Errorf("%s: expected %d/%d, got %d/%d", test.name, test.appear, test.wait, cm.BuildAppearTimeoutS, cm.GreenTimeoutS)
+		}
+	}
+}
@@ -3,83 +3,303 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/kudrykv/services-deploy-monitor/app/service"
 	"io/ioutil"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"text/template"
+	"time"
 )
 
-func ParseConfig(ptf string, slacks map[string]service.Slack) service.Config {
+type configParser struct {
+	file   string
+	slacks map[string]service.Slack
+	errs   ConfigErrors
+}
+
+// ParseConfig reads the routing rules. It goes through the whole file and
+// returns every problem it finds as ConfigErrors.
+func ParseConfig(ptf string, slacks map[string]service.Slack) (service.Config, error) {
+	config := service.Config{Slacks: slacks}
+
 	bts, err := ioutil.ReadFile(ptf)
 	if err != nil {
-		panic(err)
+		return config, err
 	}
 
 	var jc jsonConfig
 	if err := json.Unmarshal(bts, &jc); err != nil {
-		panic(err)
+		return config, ConfigErrors{{File: ptf, Err: err}}
+	}
+
+	p := configParser{file: ptf, slacks: slacks}
+
+	for idx, jr := range jc.Cvs.Rules {
+		path := "cvs.rules[" + strconv.Itoa(idx) + "]"
+		rule := service.Rule{
+			Name:     jr.Name,
+			Priority: jr.Priority,
+			Org:      jr.Org,
+			Repos:    jr.Repos,
+			Continue: jr.Continue,
+			Repo:     p.regexp(path+".repo", jr.Repo),
+			Branch:   p.regexp(path+".branch", jr.Branch),
+			Tag:      p.regexp(path+".tag", jr.Tag),
+		}
+
+		if len(rule.Name) == 0 {
+			rule.Name = "rule " + strconv.Itoa(idx)
+		}
+
+		if len(jr.Org) == 0 && len(jr.Repo) == 0 && len(jr.Repos) == 0 && len(jr.Branch) == 0 && len(jr.Tag) == 0 {
+			p.errs.add(p.file, path, errors.New("rule has no conditions, set org, repo, repos, branch or tag"))
+		}
+
+		rule.Systems = p.systems(path, rule.Name, jr.JsonCvsItem)
+		config.Cvs.Rules = append(config.Cvs.Rules, rule)
+	}
+
+	// maps have no order, so the legacy sections go in pattern order, tags before branches
+	for _, ptn := range sortedKeys(jc.Cvs.Tags) {
+		path := keyPath("cvs.tags", ptn)
+		config.Cvs.Rules = append(config.Cvs.Rules, service.Rule{
+			Name:    "tag " + ptn,
+			Tag:     p.regexp(path, ptn),
+			Systems: p.systems(path, ptn, jc.Cvs.Tags[ptn]),
+		})
+	}
+
+	for _, ptn := range sortedKeys(jc.Cvs.Branches) {
+		path := keyPath("cvs.branches", ptn)
+		config.Cvs.Rules = append(config.Cvs.Rules, service.Rule{
+			Name:    "branch " + ptn,
+			Branch:  p.regexp(path, ptn),
+			Systems: p.systems(path, ptn, jc.Cvs.Branches[ptn]),
+		})
+	}
+
+	service.SortRules(config.Cvs.Rules)
+
+	for idx, jm := range jc.Monitors {
+		config.Monitors = append(config.Monitors, p.monitor("monitors["+strconv.Itoa(idx)+"]", jm))
+	}
+
+	return config, p.errs.errOrNil()
+}
+
+func (p *configParser) monitor(path string, jm JsonMonitor) service.MonitorOverride {
+	if len(jm.Org) == 0 && len(jm.Repo) == 0 && len(jm.Branch) == 0 && len(jm.Tag) == 0 {
+		p.errs.add(p.file, path, errors.New("override has no conditions, set org, repo, branch or tag"))
+	}
+
+	// an explicit zero turns the setting off, a missing one keeps it
+	var cleared []string
+	optional := func(key string, value *int) int {
+		if value == nil {
+			return 0
+		}
+
+		if *value == 0 {
+			cleared = append(cleared, key)
+		}
+
+		return *value
+	}
+
+	jitterPercent := optional(service.SettingJitterPercent, jm.JitterPercent)
+	webhookFallbackS := optional(service.SettingWebhookFallback, jm.WebhookFallbackS)
+	failedTestsMax := optional(service.SettingFailedTests, jm.FailedTestsMax)
+	logTailLines := optional(service.SettingLogTailLines, jm.LogTailLines)
+	logTailBytes := optional(service.SettingLogTailBytes, jm.LogTailBytes)
+	retries := optional(service.SettingRetries, jm.Retries)
+
+	progressNotifications := false
+	if jm.ProgressNotifications != nil {
+		progressNotifications = *jm.ProgressNotifications
+		if !progressNotifications {
+			cleared = append(cleared, service.SettingProgressNotifications)
+		}
 	}
 
-	config := service.Config{
-		Cvs: service.Cvs{
-			Branches: map[*regexp.Regexp]service.Systems{},
+	numbers := []struct {
+		key   string
+		value int
+	}{
+		{"poll_interval_s", jm.PollIntervalS},
+		{"poll_max_interval_s", jm.PollMaxIntervalS},
+		{"backoff_percent", jm.BackoffPercent},
+		{"jitter_percent", jitterPercent},
+		{"appear_timeout_s", jm.AppearTimeoutS},
+		{"green_timeout_s", jm.GreenTimeoutS},
+		{"webhook_fallback_s", webhookFallbackS},
+		{"failed_tests_max", failedTestsMax},
+		{"log_tail_lines", logTailLines},
+		{"log_tail_bytes", logTailBytes},
+		{"retries", retries},
+	}
+
+	for _, n := range numbers {
+		if n.value < 0 {
+			p.errs.add(p.file, path+"."+n.key, errors.New("must not be negative"))
+		}
+	}
+
+	if jm.BackoffPercent > 0 && jm.BackoffPercent < 100 {
+		p.errs.add(p.file, path+".backoff_percent", errors.New("must be at least 100"))
+	}
+
+	if len(jm.Provider) > 0 && !service.Contains(service.CiProviders, jm.Provider) {
+		p.errs.add(p.file, path+".provider", fmt.Errorf("unknown ci provider %q", jm.Provider))
+	}
+
+	for idx, name := range jm.RequiredJobs {
+		if service.Contains(jm.OptionalJobs, name) {
+			p.errs.add(p.file, path+".required_jobs["+strconv.Itoa(idx)+"]", fmt.Errorf("job %q is both required and optional", name))
+		}
+	}
+
+	if jitterPercent > 100 {
+		p.errs.add(p.file, path+".jitter_percent", errors.New("must be at most 100"))
+	}
+
+	// the monitor does not poll before the fallback, so the build would time out unseen
+	if webhookFallbackS > 0 && jm.AppearTimeoutS > 0 && webhookFallbackS >= jm.AppearTimeoutS {
+		p.errs.add(p.file, path+".webhook_fallback_s", errors.New("must be less than appear_timeout_s"))
+	}
+
+	return service.MonitorOverride{
+		Org:    jm.Org,
+		Repo:   p.regexp(path+".repo", jm.Repo),
+		Branch: p.regexp(path+".branch", jm.Branch),
+		Tag:    p.regexp(path+".tag", jm.Tag),
+		Settings: service.MonitorSettings{
+			PollInterval:    time.Duration(jm.PollIntervalS) * time.Second,
+			PollMaxInterval: time.Duration(jm.PollMaxIntervalS) * time.Second,
+			BackoffPercent:  jm.BackoffPercent,
+			JitterPercent:   jitterPercent,
+			AppearTimeout:   time.Duration(jm.AppearTimeoutS) * time.Second,
+			GreenTimeout:    time.Duration(jm.GreenTimeoutS) * time.Second,
+			WebhookFallback: time.Duration(webhookFallbackS) * time.Second,
+			Provider:        jm.Provider,
+			RequiredJobs:    jm.RequiredJobs,
+			OptionalJobs:    jm.OptionalJobs,
+
+			ProgressNotifications: progressNotifications,
+			FailedTests:           failedTestsMax,
+			LogTailLines:          logTailLines,
+			LogTailBytes:          logTailBytes,
+			Retries:               retries,
 		},
+		Cleared: cleared,
+	}
+}
+
+// sortedKeys lists the keys of a map keyed by strings in order, so that the errors come out in the same order.
+func sortedKeys(items interface{}) []string {
+	keys := []string{}
+	for _, key := range reflect.ValueOf(items).MapKeys() {
+		keys = append(keys, key.String())
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (p *configParser) regexp(path, ptn string) *regexp.Regexp {
+	if len(ptn) == 0 {
+		return nil
 	}
 
-	for ptn, rest := range jc.Cvs.Branches {
-		r := regexp.MustCompile(ptn)
+	r, err := regexp.Compile(ptn)
+	if err != nil {
+		p.errs.add(p.file, path, err)
+		return nil
+	}
 
-		ss := service.Systems{
-			Github:   map[string]service.SendPack{},
-			CircleCi: map[string]map[string]service.SendPack{},
+	return r
+}
+
+func (p *configParser) systems(path, name string, rest JsonCvsItem) service.Systems {
+	ss := service.Systems{
+		Github: map[string]service.SendPack{},
+		Ci:     map[string]map[string]map[string]service.SendPack{},
+	}
+
+	for _, event := range sortedKeys(rest.Github) {
+		eventPath := path + ".github." + event
+		if !service.Contains(service.NotifiedEvents, event) {
+			p.errs.add(p.file, eventPath, fmt.Errorf("unknown event %q", event))
 		}
 
-		for event, smth := range rest.Github {
-			tpl := template.New(ptn + event)
-			parsed, err := tpl.Parse(smth.Message)
-			if err != nil {
-				panic(err)
-			}
+		ss.Github[event] = p.sendPack(eventPath, name+event, rest.Github[event])
+	}
 
-			slack, ok := slacks[smth.Slack]
-			if !ok {
-				panic(errors.New("slack " + smth.Slack + " has not been found"))
-			}
+	for _, provider := range sortedKeys(rest.Ci) {
+		providerPath := path + ".ci." + provider
+		if !service.Contains(service.CiProviders, provider) {
+			p.errs.add(p.file, providerPath, fmt.Errorf("unknown ci provider %q", provider))
+		}
 
-			ss.Github[event] = service.SendPack{
-				Message: parsed,
-				Room:    smth.Room,
-				Slack:   slack,
-			}
+		ss.Ci[provider] = p.ciEvents(providerPath, name+provider, rest.Ci[provider])
+	}
+
+	if len(rest.CircleCi) > 0 {
+		if _, ok := rest.Ci[service.ProviderCircleCi]; ok {
+			p.errs.add(p.file, path+".circle_ci", errors.New("both circle_ci and ci.circleci are set, keep one"))
+		} else {
+			ss.Ci[service.ProviderCircleCi] = p.ciEvents(path+".circle_ci", name+service.ProviderCircleCi, rest.CircleCi)
 		}
+	}
+
+	return ss
+}
+
+func (p *configParser) ciEvents(path, name string, rest map[string]map[string]JsonSystems) map[string]map[string]service.SendPack {
+	packs := map[string]map[string]service.SendPack{}
+	for _, event := range sortedKeys(rest) {
+		eventPath := path + "." + event
+		if !service.Contains(service.NotifiedEvents, event) {
+			p.errs.add(p.file, eventPath, fmt.Errorf("unknown event %q", event))
+		}
+
+		neededMap := map[string]service.SendPack{}
 
-		for event, mapOfSystems := range rest.CircleCi {
-			neededMap := map[string]service.SendPack{}
-
-			for status, smth := range mapOfSystems {
-				tpl := template.New(ptn + event + status)
-				parsed, err := tpl.Parse(smth.Message)
-				if err != nil {
-					panic(err)
-				}
-
-				slack, ok := slacks[smth.Slack]
-				if !ok {
-					panic(errors.New("slack " + smth.Slack + " has not been found"))
-				}
-
-				neededMap[status] = service.SendPack{
-					Message: parsed,
-					Room:    smth.Room,
-					Slack:   slack,
-				}
+		for _, status := range sortedKeys(rest[event]) {
+			statusPath := eventPath + "." + status
+			if !service.Contains(service.BuildStatuses, status) {
+				p.errs.add(p.file, statusPath, fmt.Errorf("unknown build status %q", status))
 			}
 
-			ss.CircleCi[event] = neededMap
+			neededMap[status] = p.sendPack(statusPath, name+event+status, rest[event][status])
 		}
 
-		config.Cvs.Branches[r] = ss
+		packs[event] = neededMap
 	}
 
-	return config
+	return packs
+}
+
+func (p *configParser) sendPack(path, name string, smth JsonSystems) service.SendPack {
+	parsed, err := template.New(name).Parse(smth.Message)
+	if err != nil {
+		p.errs.add(p.file, path+".message", err)
+	}
+
+	if len(smth.Message) == 0 {
+		p.errs.add(p.file, path+".message", errors.New("message is empty"))
+	}
+
+	if _, ok := p.slacks[smth.Slack]; !ok {
+		p.errs.add(p.file, path+".slack", fmt.Errorf("slack %q has not been found", smth.Slack))
+	}
+
+	return service.SendPack{
+		Message: parsed,
+		Room:    smth.Room,
+		Slack:   smth.Slack,
+	}
 }
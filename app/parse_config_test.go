@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSlacks = `{"team": {"url": "https://hooks.slack.com/services/T/B/X"}}`
+
+func writeConfigs(t *testing.T, patterns, slack string) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patternsFile := filepath.Join(dir, "send-patterns.json")
+	slackFile := filepath.Join(dir, "slack-config.json")
+
+	if err := ioutil.WriteFile(patternsFile, []byte(patterns), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(slackFile, []byte(slack), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return patternsFile, slackFile, func() { os.RemoveAll(dir) }
+}
+
+// errorPaths lists the file base names and paths of the config errors, so that tests do not depend on the temp dir.
+func errorPaths(t *testing.T, err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	ce, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("expected config errors, got %v", err)
+	}
+
+	var paths []string
+	for _, e := range ce {
+		paths = append(paths, filepath.Base(e.File)+":"+e.Path)
+	}
+
+	return paths
+}
+
+func TestParseConfigReportsPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns string
+		expected []string
+	}{
+		{
+			name: "valid",
+			patterns: `{
+				"cvs": {
+					"rules": [{
+						"branch": "^master$",
+						"github": {"pull_request_merged": {"slack": "team", "room": "r", "message": "{{.Repo}}"}},
+						"ci": {"jenkins": {"pull_request_merged": {"success": {"slack": "team", "room": "r", "message": "ok"}}}}
+					}],
+					"tags": {"^v": {"circle_ci": {"release": {"build_failed": {"slack": "team", "room": "r", "message": "no"}}}}}
+				},
+				"monitors": [{"repo": "^api$", "required_jobs": ["build"], "retries": 1}]
+			}`,
+		},
+		{
+			name:     "broken json",
+			patterns: `{"cvs": [`,
+			expected: []string{"send-patterns.json:"},
+		},
+		{
+			name: "rule problems",
+			patterns: `{"cvs": {"rules": [
+				{"github": {"pull_request_merged": {"slack": "team", "room": "r", "message": "m"}}},
+				{"branch": "(", "repo": "[", "github": {"pushed": {"slack": "team", "room": "r", "message": "m"}}}
+			]}}`,
+			expected: []string{
+				"send-patterns.json:cvs.rules[0]",
+				"send-patterns.json:cvs.rules[1].repo",
+				"send-patterns.json:cvs.rules[1].branch",
+				"send-patterns.json:cvs.rules[1].github.pushed",
+			},
+		},
+		{
+			name: "send pack problems",
+			patterns: `{"cvs": {"rules": [{"branch": "^master$",
+				"github": {"create": {"slack": "other", "room": "r", "message": "{{.Repo"}},
+				"ci": {
+					"travis": {"create": {"success": {"slack": "team", "room": "r", "message": "m"}}},
+					"circleci": {"create": {"exploded": {"slack": "team", "room": "r", "message": ""}}}
+				}
+			}]}}`,
+			expected: []string{
+				"send-patterns.json:cvs.rules[0].github.create.message",
+				"send-patterns.json:cvs.rules[0].github.create.slack",
+				"send-patterns.json:cvs.rules[0].ci.circleci.create.exploded",
+				"send-patterns.json:cvs.rules[0].ci.circleci.create.exploded.message",
+				"send-patterns.json:cvs.rules[0].ci.travis",
+			},
+		},
+		{
+			name: "legacy sections",
+			patterns: `{"cvs": {
+				"tags": {"^v(": {"github": {"release": {"slack": "team", "room": "r", "message": "m"}}}},
+				"branches": {"^master$": {
+					"circle_ci": {"create": {"success": {"slack": "team", "room": "r", "message": "m"}}},
+					"ci": {"circleci": {"create": {"success": {"slack": "team", "room": "r", "message": "m"}}}}
+				}}
+			}}`,
+			expected: []string{
+				`send-patterns.json:cvs.tags["^v("]`,
+				`send-patterns.json:cvs.branches["^master$"].circle_ci`,
+			},
+		},
+		{
+			name: "monitor problems",
+			patterns: `{"monitors": [
+				{"poll_interval_s": 10},
+				{"repo": "^api$", "backoff_percent": 50, "jitter_percent": 120, "log_tail_lines": -1, "provider": "travis",
+				 "required_jobs": ["build", "lint"], "optional_jobs": ["lint"]}
+			]}`,
+			expected: []string{
+				"send-patterns.json:monitors[0]",
+				"send-patterns.json:monitors[1].log_tail_lines",
+				"send-patterns.json:monitors[1].backoff_percent",
+				"send-patterns.json:monitors[1].provider",
+				"send-patterns.json:monitors[1].required_jobs[1]",
+				"send-patterns.json:monitors[1].jitter_percent",
+			},
+		},
+		{
+			name: "webhook fallback past the appear timeout",
+			patterns: `{"monitors": [
+				{"repo": "^api$", "webhook_fallback_s": 60, "appear_timeout_s": 300},
+				{"repo": "^web$", "webhook_fallback_s": 300, "appear_timeout_s": 300}
+			]}`,
+			expected: []string{"send-patterns.json:monitors[1].webhook_fallback_s"},
+		},
+	}
+
+	for _, test := range tests {
+		patterns, slack, cleanup := writeConfigs(t, test.patterns, testSlacks)
+
+		_, err := loadConfig(patterns, slack)
+		if got := errorPaths(t, err); strings.Join(got, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.name, strings.Join(test.expected, "\n"), strings.Join(got, "\n"))
+		}
+
+		cleanup()
+	}
+}
+
+func TestParseConfigOrdersRules(t *testing.T) {
+	patterns, slack, cleanup := writeConfigs(t, `{"cvs": {
+		"rules": [
+			{"name": "low", "branch": "^master$"},
+			{"name": "high", "priority": 10, "branch": "^master$"}
+		],
+		"branches": {"^b$": {}, "^a$": {}},
+		"tags": {"^v": {}}
+	}}`, testSlacks)
+	defer cleanup()
+
+	loaded, err := loadConfig(patterns, slack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, rule := range loaded.Cvs.Rules {
+		names = append(names, rule.Name)
+	}
+
+	if strings.Join(names, ",") != "high,low,tag ^v,branch ^a$,branch ^b$" {
+		t.Errorf("unexpected order: %v", names)
+	}
+}
+
+func TestParseConfigClearsExplicitZeros(t *testing.T) {
+	patterns, slack, cleanup := writeConfigs(t, `{"monitors": [
+		{"repo": "^web$", "retries": 0, "progress_notifications": false, "log_tail_lines": 5}
+	]}`, testSlacks)
+	defer cleanup()
+
+	loaded, err := loadConfig(patterns, slack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	override := loaded.Monitors[0]
+	if strings.Join(override.Cleared, ",") != "retries,progress_notifications" {
+		t.Errorf("unexpected cleared settings: %v", override.Cleared)
+	}
+
+	if override.Settings.LogTailLines != 5 {
+		t.Errorf("expected 5 log tail lines, got %d", override.Settings.LogTailLines)
+	}
+}
+
+func TestParseSlackReportsPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		slack    string
+		expected []string
+	}{
+		{name: "valid", slack: testSlacks},
+		{name: "broken json", slack: `{"team": `, expected: []string{"slack-config.json:"}},
+		{
+			name:  "bad urls",
+			slack: `{"b": {"url": "ftp://files"}, "a": {}, "c": {"url": "https://hooks.slack.com/x"}}`,
+			expected: []string{
+				`slack-config.json:["a"].url`,
+				`slack-config.json:["b"].url`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		_, slack, cleanup := writeConfigs(t, `{}`, test.slack)
+
+		_, err := ParseSlack(slack)
+		if got := errorPaths(t, err); strings.Join(got, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+
+		cleanup()
+	}
+}
+
+func TestLoadConfigReportsBothFiles(t *testing.T) {
+	patterns, slack, cleanup := writeConfigs(t,
+		`{"cvs": {"rules": [{"branch": "^master$", "github": {"create": {"slack": "team", "room": "r", "message": "m"}}}]}}`,
+		`{"team": {}}`,
+	)
+	defer cleanup()
+
+	_, err := loadConfig(patterns, slack)
+
+	// the broken slack is left out, so the rule cannot find it either
+	expected := []string{
+		`slack-config.json:["team"].url`,
+		"send-patterns.json:cvs.rules[0].github.create.slack",
+	}
+
+	if got := errorPaths(t, err); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	patterns, slack, cleanup := writeConfigs(t, `{"monitors": [{"poll_interval_s": 10}]}`, testSlacks)
+	defer cleanup()
+
+	valid, _, cleanupValid := writeConfigs(t, `{}`, testSlacks)
+	defer cleanupValid()
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		output string
+	}{
+		{name: "valid", args: []string{"-patterns", valid, "-slack", slack}, code: 0, output: "OK"},
+		{name: "invalid", args: []string{"-patterns", patterns, "-slack", slack}, code: 1, output: "monitors[0]: override has no conditions"},
+		{name: "missing file", args: []string{"-patterns", patterns + ".missing", "-slack", slack}, code: 1, output: "no such file"},
+		{name: "unknown flag", args: []string{"-strict"}, code: 2, output: "flag provided but not defined"},
+	}
+
+	for _, test := range tests {
+		out := &bytes.Buffer{}
+		if code := validate(test.args, out); code != test.code || !strings.Contains(out.String(), test.output) {
+			t.Errorf("%s: expected %d %q, got %d %q", test.name, test.code, test.output, code, out.String())
+		}
+	}
+}
@@ -2,25 +2,45 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/kudrykv/services-deploy-monitor/app/service"
 	"io/ioutil"
+	"net/url"
 )
 
-func ParseSlack(ptf string) map[string]service.Slack {
+// ParseSlack reads the slack workspaces and returns every problem it finds as ConfigErrors.
+func ParseSlack(ptf string) (map[string]service.Slack, error) {
 	bts, err := ioutil.ReadFile(ptf)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var jsonSlacks map[string]JsonSlack
-	if json.Unmarshal(bts, &jsonSlacks); err != nil {
-		panic(err)
+	if err := json.Unmarshal(bts, &jsonSlacks); err != nil {
+		return nil, ConfigErrors{{File: ptf, Err: err}}
 	}
 
+	var errs ConfigErrors
 	slacks := map[string]service.Slack{}
-	for key, jsonSlack := range jsonSlacks {
+	for _, key := range sortedKeys(jsonSlacks) {
+		jsonSlack := jsonSlacks[key]
+		path := keyPath("", key) + ".url"
+
+		if len(jsonSlack.Url) == 0 {
+			errs.add(ptf, path, errors.New("url is empty"))
+			continue
+		}
+
+		if u, err := url.Parse(jsonSlack.Url); err != nil {
+			errs.add(ptf, path, err)
+			continue
+		} else if u.Scheme != "https" && u.Scheme != "http" {
+			errs.add(ptf, path, errors.New("url must be http(s)"))
+			continue
+		}
+
 		slacks[key] = service.NewSlack(jsonSlack.Url)
 	}
 
-	return slacks
+	return slacks, errs.errOrNil()
 }
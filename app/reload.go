@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"github.com/Sirupsen/logrus"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/logging"
+	"github.com/kudrykv/services-deploy-monitor/app/service"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchConfig reloads the config files on SIGHUP or when they change on disk.
+// A config that does not pass validation is reported and the running one stays active.
+func watchConfig(ctx context.Context, interval time.Duration, patterns, slack string, apply func(service.Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer signal.Stop(hup)
+
+	last := modTimes(patterns, slack)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+			last = modTimes(patterns, slack)
+			reloadConfig("sighup", patterns, slack, apply)
+
+		case <-ticker.C:
+			current := modTimes(patterns, slack)
+			if current == last {
+				continue
+			}
+
+			last = current
+			reloadConfig("file change", patterns, slack, apply)
+		}
+	}
+}
+
+func reloadConfig(reason, patterns, slack string, apply func(service.Config)) {
+	fields := logrus.Fields{"reason": reason}
+
+	loaded, err := loadConfig(patterns, slack)
+	if err != nil {
+		logging.WithFields(fields).WithFields(logrus.Fields{"err": err.Error()}).Error("reload config, keep the old one")
+		return
+	}
+
+	apply(loaded)
+	logging.WithFields(fields).Info("config reloaded")
+}
+
+func modTimes(patterns, slack string) [2]time.Time {
+	var times [2]time.Time
+	for idx, path := range []string{patterns, slack} {
+		if fi, err := os.Stat(path); err == nil {
+			times[idx] = fi.ModTime()
+		}
+	}
+
+	return times
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"github.com/kudrykv/services-deploy-monitor/app/service"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func ruleName(cfg service.Config) string {
+	if len(cfg.Cvs.Rules) == 0 {
+		return ""
+	}
+
+	return cfg.Cvs.Rules[0].Name
+}
+
+func TestReloadKeepsOldConfigOnError(t *testing.T) {
+	patterns, slack, cleanup := writeConfigs(t, `{"cvs": {"rules": [{"name": "old", "branch": "^master$"}]}}`, testSlacks)
+	defer cleanup()
+
+	initial, err := loadConfig(patterns, slack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routing := service.NewRouting(initial)
+
+	if err := ioutil.WriteFile(patterns, []byte(`{"cvs": {"rules": [{"name": "new", "branch": "^master$",
+		"github": {"create": {"slack": "team", "room": "r", "message": "m"}}}]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloadConfig("test", patterns, slack, routing.Set)
+
+	cfg := routing.Config()
+	if ruleName(cfg) != "new" || cfg.Slacks["team"] == nil {
+		t.Fatalf("expected the new rules with their slacks, got %q %v", ruleName(cfg), cfg.Slacks)
+	}
+
+	// the rule points at a slack the new slack config lacks
+	if err := ioutil.WriteFile(slack, []byte(`{"other": {"url": "https://hooks.slack.com/y"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloadConfig("test", patterns, slack, routing.Set)
+
+	cfg = routing.Config()
+	if ruleName(cfg) != "new" || cfg.Slacks["team"] == nil || cfg.Slacks["other"] != nil {
+		t.Errorf("expected the old config to stay, got %q %v", ruleName(cfg), cfg.Slacks)
+	}
+}
+
+func TestWatchConfigReloadsOnChange(t *testing.T) {
+	patterns, slack, cleanup := writeConfigs(t, `{}`, testSlacks)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	applied := make(chan service.Config, 1)
+	done := make(chan struct{})
+	go func() {
+		watchConfig(ctx, 5*time.Millisecond, patterns, slack, func(cfg service.Config) {
+			applied <- cfg
+		})
+		close(done)
+	}()
+
+	// let the watcher take the first mod times
+	time.Sleep(20 * time.Millisecond)
+
+	if err := ioutil.WriteFile(patterns, []byte(`{"cvs": {"rules": [{"name": "changed", "branch": "^master$"}]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(patterns, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case cfg := <-applied:
+		if ruleName(cfg) != "changed" {
+			t.Errorf("unexpected config applied: %q", ruleName(cfg))
+		}
+
+	case <-time.After(time.Second):
+		t.Fatal("config was not reloaded")
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watcher did not stop")
+	}
+}
@@ -1,26 +1,132 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"github.com/Sirupsen/logrus"
 	"github.com/kudrykv/services-deploy-monitor/app/config"
 	"github.com/kudrykv/services-deploy-monitor/app/internal/httputil"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/kvstore"
 	"github.com/kudrykv/services-deploy-monitor/app/internal/logging"
+	"github.com/rs/xid"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
+var ErrMonitorNotFound = errors.New("monitor not found")
+
 type ciMonitor struct {
-	cm config.Monitor
-	ci CircleCi
-	gh GhWrap
+	defaults  MonitorSettings
+	overrides atomic.Value
+	clock     Clock
+	providers map[string]CiProvider
+	gh        GhWrap
+	store     *kvstore.Store
+	// window is the share window of the poller, the polls are aligned to it
+	window time.Duration
+
+	mu      sync.Mutex
+	running map[string]*runningMonitor
+}
+
+// commitClockSkew widens the build lookup for the commit dates set by a wrong clock.
+const commitClockSkew = 10 * time.Minute
+
+const (
+	cancelManual     = "manual"
+	cancelSuperseded = "superseded"
+)
+
+type runningMonitor struct {
+	state  MonitorState
+	cancel context.CancelFunc
+	// reason is empty when the monitor stops because the service shuts down
+	reason       string
+	notify       bool
+	supersededBy string
+	results      chan CiResult
+	// saved is the state as last persisted, to skip rewriting the store when nothing changed
+	saved []byte
+}
+
+func NewCiMonitor(cm config.Monitor, overrides []MonitorOverride, gh GhWrap, providers []CiProvider, store *kvstore.Store) CiMonitor {
+	s := &ciMonitor{
+		defaults:  defaultSettings(cm),
+		clock:     realClock{},
+		providers: map[string]CiProvider{},
+		gh:        gh,
+		store:     store,
+		window:    time.Duration(cm.PollShareWindowMs) * time.Millisecond,
+		running:   map[string]*runningMonitor{},
+	}
+
+	for _, provider := range providers {
+		s.providers[provider.Name()] = provider
+	}
+
+	s.overrides.Store(overrides)
+
+	return s
+}
+
+// SetOverrides swaps the per repo settings. Running monitors pick them up on the next poll.
+func (s *ciMonitor) SetOverrides(overrides []MonitorOverride) {
+	s.overrides.Store(overrides)
+}
+
+func (s *ciMonitor) settings(event Event) MonitorSettings {
+	return settingsFor(s.defaults, s.overrides.Load().([]MonitorOverride), event)
+}
+
+func (s *ciMonitor) List() []MonitorState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make([]MonitorState, 0, len(s.running))
+	for _, rm := range s.running {
+		states = append(states, rm.state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].StartedAt.Before(states[j].StartedAt)
+	})
+
+	return states
 }
 
-func NewCiMonitor(cm config.Monitor, gh GhWrap, ci CircleCi) CiMonitor {
-	return &ciMonitor{
-		cm: cm,
-		ci: ci,
-		gh: gh,
+func (s *ciMonitor) Get(id string) (MonitorState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rm, ok := s.running[id]
+	if !ok {
+		return MonitorState{}, false
 	}
+
+	return rm.state, true
+}
+
+// Cancel stops the monitor. With notify it reports BuildStatusCanceled like any other outcome.
+func (s *ciMonitor) Cancel(id string, notify bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rm, ok := s.running[id]
+	if !ok {
+		return ErrMonitorNotFound
+	}
+
+	rm.reason = cancelManual
+	rm.notify = notify
+	rm.cancel()
+
+	return nil
 }
 
 func (s *ciMonitor) Monitor(ctx context.Context, hook AggregatedWebhook, f func(context.Context, Event)) {
@@ -30,6 +136,7 @@ func (s *ciMonitor) Monitor(ctx context.Context, hook AggregatedWebhook, f func(
 	}
 
 	var event Event
+	var committedAt time.Time
 
 	switch hook.Event {
 	case PullRequestEvent:
@@ -39,7 +146,7 @@ func (s *ciMonitor) Monitor(ctx context.Context, hook AggregatedWebhook, f func(
 		}
 
 		event = Event{
-			Event:     hook.Event + "_merged",
+			Event:     PullRequestMergedEvent,
 			Org:       hook.PullRequestEvent.GetRepo().GetOwner().GetLogin(),
 			Repo:      hook.PullRequestEvent.GetRepo().GetName(),
 			BranchRef: hook.PullRequestEvent.GetPullRequest().GetBase().GetRef(),
@@ -48,13 +155,25 @@ func (s *ciMonitor) Monitor(ctx context.Context, hook AggregatedWebhook, f func(
 			PrNumber:  hook.PullRequestEvent.GetPullRequest().GetNumber(),
 		}
 
+		committedAt = hook.PullRequestEvent.GetPullRequest().GetMergedAt()
+
 	case ReleaseEvent:
 		event = Event{
-			Event:     hook.Event,
-			Org:       hook.ReleaseEvent.GetRepo().GetOwner().GetLogin(),
-			Repo:      hook.ReleaseEvent.GetRepo().GetName(),
-			BranchRef: hook.ReleaseEvent.GetRelease().GetTargetCommitish(),
-			Tag:       hook.ReleaseEvent.GetRelease().GetTagName(),
+			Event:       hook.Event,
+			Org:         hook.ReleaseEvent.GetRepo().GetOwner().GetLogin(),
+			Repo:        hook.ReleaseEvent.GetRepo().GetName(),
+			BranchRef:   hook.ReleaseEvent.GetRelease().GetTargetCommitish(),
+			Tag:         hook.ReleaseEvent.GetRelease().GetTagName(),
+			ReleaseName: hook.ReleaseEvent.GetRelease().GetName(),
+			ReleaseUrl:  hook.ReleaseEvent.GetRelease().GetHTMLURL(),
+		}
+
+		// the tag may point to an older commit, its date bounds the build lookup
+		rc, err := s.gh.Commit(ctx, event.Org, event.Repo, event.Tag)
+		if err != nil {
+			logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Warn("fetch release commit, search without a bound")
+		} else {
+			committedAt = rc.GetCommit().GetCommitter().GetDate()
 		}
 
 	case CreateEvent:
@@ -68,6 +187,7 @@ func (s *ciMonitor) Monitor(ctx context.Context, hook AggregatedWebhook, f func(
 			Org:       hook.CreateEvent.GetRepo().GetOwner().GetLogin(),
 			Repo:      hook.CreateEvent.GetRepo().GetName(),
 			BranchRef: hook.CreateEvent.GetRef(),
+			RefType:   hook.CreateEvent.GetRefType(),
 		}
 
 		rc, err := s.gh.Commit(ctx, event.Org, event.Repo, event.BranchRef)
@@ -77,6 +197,24 @@ func (s *ciMonitor) Monitor(ctx context.Context, hook AggregatedWebhook, f func(
 		}
 
 		event.Sha = rc.GetSHA()
+		committedAt = rc.GetCommit().GetCommitter().GetDate()
+
+	case WorkflowRunEvent, CheckSuiteEvent:
+		result, ok := actionsResult(hook)
+		if !ok {
+			logging.WithFields(fields).Info("skip check suite of another app")
+			return
+		}
+
+		taken := s.Deliver(result)
+		logging.WithFields(fields).WithFields(logrus.Fields{
+			"repo":     result.Repo,
+			"sha":      result.Sha,
+			"status":   result.Status,
+			"monitors": taken,
+		}).Info("ci result delivered")
+
+		return
 
 	default:
 		logging.WithFields(fields).Error("unknown event: " + hook.Event)
@@ -86,103 +224,732 @@ func (s *ciMonitor) Monitor(ctx context.Context, hook AggregatedWebhook, f func(
 	event.Source = sourceGithub
 	f(ctx, event)
 
+	settings := s.settings(event)
+	now := s.clock.Now()
+	state := MonitorState{
+		Id:           xid.New().String(),
+		RequestId:    httputil.GetRequestId(ctx),
+		Event:        event,
+		Provider:     settings.Provider,
+		Phase:        PhaseSearching,
+		StartedAt:    now,
+		Deadline:     now.Add(settings.AppearTimeout),
+		PollInterval: settings.PollInterval,
+		CommittedAt:  committedAt,
+	}
+
+	if event.Event == PullRequestMergedEvent {
+		s.supersede(state)
+	}
+
+	s.watch(ctx, state, f)
+}
+
+// Resume picks up the monitors which did not finish before the restart.
+func (s *ciMonitor) Resume(ctx context.Context, f func(context.Context, Event)) {
+	var states []MonitorState
+
+	err := s.store.Each(func(key string, value json.RawMessage) error {
+		var state MonitorState
+		if err := json.Unmarshal(value, &state); err != nil {
+			return err
+		}
+
+		states = append(states, state)
+		return nil
+	})
+
+	if err != nil {
+		logging.WithFields(logrus.Fields{"err": err}).Error("load monitors")
+		return
+	}
+
+	for _, state := range states {
+		logging.WithFields(logrus.Fields{
+			"request_id": state.RequestId,
+			"monitor_id": state.Id,
+			"repo":       state.Event.Repo,
+		}).Info("resume monitor")
+
+		go s.watch(httputil.AddCustomRequestId(ctx, state.RequestId), state, f)
+	}
+}
+
+func (s *ciMonitor) watch(ctx context.Context, state MonitorState, f func(context.Context, Event)) {
+	fields := logrus.Fields{
+		"request_id": httputil.GetRequestId(ctx),
+		"monitor_id": state.Id,
+		"event":      state.Event.Event,
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	rm := s.register(state, cancel)
+	defer s.unregister(state.Id, cancel)
+
+	if len(state.Provider) == 0 {
+		// saved before the providers were configurable
+		state.Provider = s.settings(state.Event).Provider
+	}
+
+	event := state.Event
+	event.Source = state.Provider
+	event.Retries = state.Retries
+
+	finish := func(status string) {
+		if err := s.store.Delete(state.Id); err != nil {
+			logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Error("forget monitor")
+		}
+
+		event.BuildStatus = status
+		f(ctx, event)
+	}
+
+	provider, ok := s.providers[state.Provider]
+	if !ok {
+		logging.WithFields(fields).WithFields(logrus.Fields{"provider": state.Provider}).Error("unknown ci provider")
+		finish(BuildStatusFetchFailed)
+		return
+	}
+
+	retrier, _ := provider.(CiRetrier)
+	if retrier == nil && s.settings(event).Retries > 0 {
+		logging.WithFields(fields).WithFields(logrus.Fields{"provider": state.Provider}).Warn("ci provider can not retry builds")
+	}
+
 	logging.WithFields(fields).Info("start timer")
-	ticker := time.NewTicker(time.Duration(s.cm.PollTimeIntervalS) * time.Second)
-	defer ticker.Stop()
-	skips := 0
 
-	greens := false
-	allGreensRestarts := 0
-	allGreensWaitTimes := s.cm.PollForGreenBuildsTimes
+	filterBranch := event.BranchRef
+	if event.Event == ReleaseEvent {
+		filterBranch = ""
+	}
+
+	shaOrTag := event.Sha
+	if len(shaOrTag) == 0 {
+		shaOrTag = event.Tag
+	}
+
+	since := state.CommittedAt
+	if !since.IsZero() {
+		since = since.Add(-commitClockSkew)
+	}
+
+	// describe adds why the builds failed to the event, the webhook does not tell which builds those are
+	describe := func(settings MonitorSettings, builds []Build, fetch bool) {
+		if fetch {
+			var err error
+			if builds, err = provider.Builds(event.Org, event.Repo, filterBranch, shaOrTag, since); err != nil {
+				logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Warn("fetch failed builds")
+				return
+			}
+
+			builds = withoutRetried(builds, state.Retried)
+		}
+
+		s.describeFailure(fields, provider, &event, settings, builds)
+	}
+
+	pollNow := false
 
-	event.Source = sourceCircleCi
 	for {
-		<-ticker.C
+		s.update(fields, state)
+		settings := s.settings(event)
 
-		filterBranch := event.BranchRef
-		if hook.Event == ReleaseEvent {
-			filterBranch = ""
+		wait := settings.jitter(state.PollInterval)
+		// with webhooks set up the first polls are only a fallback for the lost ones
+		if quiet := state.StartedAt.Add(settings.WebhookFallback).Sub(s.clock.Now()); quiet > wait {
+			wait = quiet
 		}
 
-		shaOrTag := event.Sha
-		if len(shaOrTag) == 0 {
-			shaOrTag = event.Tag
+		wait = s.align(wait)
+
+		if pollNow {
+			wait = 0
+			pollNow = false
 		}
 
-		builds, err := s.ci.BuildsForProjectMatching(event.Org, event.Repo, filterBranch, shaOrTag)
+		select {
+		case <-watchCtx.Done():
+			s.mu.Lock()
+			reason, notify, supersededBy := rm.reason, rm.notify, rm.supersededBy
+			s.mu.Unlock()
+
+			switch reason {
+			case cancelSuperseded:
+				logging.WithFields(fields).WithFields(logrus.Fields{"superseded_by": supersededBy}).Info("monitor superseded")
+				event.SupersededBy = supersededBy
+				finish(BuildStatusSuperseded)
+
+			case cancelManual:
+				logging.WithFields(fields).WithFields(logrus.Fields{"notify": notify}).Info("monitor canceled")
+				if notify {
+					finish(BuildStatusCanceled)
+				} else if err := s.store.Delete(state.Id); err != nil {
+					logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Error("forget monitor")
+				}
+
+			default:
+				// shutting down, keep the state to resume it later
+				logging.WithFields(fields).Info("stop monitor")
+			}
+
+			return
+
+		case result := <-rm.results:
+			rf := logging.WithFields(fields).WithFields(logrus.Fields{
+				"kind":   result.Kind,
+				"name":   result.Name,
+				"status": result.Status,
+			})
+
+			state.LastCiStatus = result.Status
+
+			switch {
+			case result.Kind == CiResultJob && !settings.counts(result.Name):
+				rf.Info("skip optional job")
+
+			case result.Kind == CiResultWorkflow && result.Status != CiSuccess && settings.selective():
+				// optional jobs fail the workflow too, let the poll sort the jobs out
+				rf.Info("workflow is not green, check the jobs")
+				pollNow = true
+
+			case result.Status == CiFailed && canRetry(retrier, state, settings):
+				// the poll finds the failed builds to retry
+				rf.Info("build failed in ci, retry")
+				pollNow = true
+
+			case result.Status == CiFailed:
+				rf.Info("build failed in ci")
+				event.FailedJobs = []string{result.Name}
+				describe(settings, nil, true)
+				finish(BuildStatusBuildFailed)
+				return
+
+			case result.Kind == CiResultWorkflow && result.Status == CiCanceled:
+				if sha, ok := s.newerOnBranch(state); ok {
+					rf.WithFields(logrus.Fields{"superseded_by": sha}).Info("build canceled by a newer commit")
+					event.SupersededBy = sha
+					finish(BuildStatusSuperseded)
+					return
+				}
+
+				rf.Info("build failed in ci")
+				event.FailedJobs = []string{result.Name}
+				finish(BuildStatusBuildFailed)
+				return
+
+			case result.Kind == CiResultWorkflow && result.Status == CiSuccess:
+				// other workflows of the commit may still run, poll right away to confirm
+				rf.Info("workflow is green, confirm")
+				state.Greens = true
+				state.Phase = PhaseConfirmingGreen
+				pollNow = true
+			}
+
+			continue
+
+		case <-s.clock.After(wait):
+		}
+
+		state.PollInterval = settings.backoff(state.PollInterval)
+
+		builds, err := provider.Builds(event.Org, event.Repo, filterBranch, shaOrTag, since)
+		now := s.clock.Now()
+		state.PolledAt = now
 
 		if err != nil {
 			logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Error("fetch build from ci")
-			event.BuildStatus = "fetch_failed"
-			f(ctx, event)
+			finish(BuildStatusFetchFailed)
 			return
 		}
 
-		if skips > s.cm.PollForBuildsTimes {
-			logging.WithFields(fields).
-				WithFields(logrus.Fields{"skips": skips}).
-				Error("did not find build in multiple consecutive attempts")
+		builds = withoutRetried(builds, state.Retried)
+		event.Jobs = jobResults(builds, now)
+		builds = settings.relevant(builds)
+		state.LastCiStatus = summarizeStatuses(builds)
 
-			event.BuildStatus = "search_failed"
-			f(ctx, event)
-			return
+		notify := func(status string) {
+			progress := event
+			progress.BuildStatus = status
+			f(ctx, progress)
 		}
 
 		if len(builds) == 0 {
-			logging.WithFields(fields).WithFields(logrus.Fields{"skips": skips}).Warn("did not find build")
-			skips += 1
+			state.Skips += 1
+
+			if now.After(state.Deadline) {
+				logging.WithFields(fields).
+					WithFields(logrus.Fields{"skips": state.Skips, "deadline": state.Deadline}).
+					Error("did not find build before the deadline")
+
+				if state.Phase == PhaseSearching {
+					finish(BuildStatusSearchFailed)
+				} else {
+					finish(BuildStatusWaitFailed)
+				}
+
+				return
+			}
+
+			logging.WithFields(fields).WithFields(logrus.Fields{"skips": state.Skips}).Warn("did not find build")
 			continue
 		}
 
+		if state.Phase == PhaseSearching {
+			// found the build, the clock for it to go green starts now
+			state.Phase = PhaseWaitingGreen
+			state.Deadline = now.Add(settings.GreenTimeout)
+			state.PollInterval = settings.PollInterval
+
+			if settings.ProgressNotifications {
+				notify(BuildStatusStarted)
+			}
+		}
+
 		for _, build := range builds {
-			if build.Status == "canceled" || build.Status == "failed" {
-				logging.WithFields(fields).Info("build failed in circleci")
-				event.BuildStatus = "build_failed"
-				f(ctx, event)
+			if build.Status != CiCanceled {
+				continue
+			}
+
+			// ci auto-cancels builds of the older commits on the branch
+			if sha, ok := s.newerOnBranch(state); ok {
+				logging.WithFields(fields).WithFields(logrus.Fields{"superseded_by": sha}).Info("build canceled by a newer commit")
+				event.SupersededBy = sha
+				finish(BuildStatusSuperseded)
 				return
 			}
 		}
 
-		allGreen := true
+		var failed []string
 		for _, build := range builds {
-			isGreen := build.Status == "success" || build.Status == "fixed"
+			if build.Status == CiCanceled || build.Status == CiFailed {
+				failed = append(failed, build.Name)
+			}
+		}
+
+		if len(failed) > 0 && canRetry(retrier, state, settings) && s.retry(fields, retrier, &state, builds) {
+			state.Retries += 1
+			event.Retries = state.Retries
+			state.Phase = PhaseWaitingGreen
+			state.Deadline = now.Add(settings.GreenTimeout)
+			state.PollInterval = settings.PollInterval
+			state.Greens = false
+
+			retried := event
+			retried.BuildStatus = BuildStatusRetried
+			retried.FailedJobs = failed
+			f(ctx, retried)
+			continue
+		}
+
+		if len(failed) > 0 && state.RetryRefused && settling(builds) && !now.After(state.Deadline) {
+			// the reruns which did start settle first, so that the failure does not race them
+			logging.WithFields(fields).WithFields(logrus.Fields{"failed": failed}).Info("wait for the reruns")
+			continue
+		}
+
+		if len(failed) > 0 {
+			logging.WithFields(fields).WithFields(logrus.Fields{"failed": failed}).Info("build failed in ci")
+			event.FailedJobs = failed
+			describe(settings, builds, false)
+			finish(BuildStatusBuildFailed)
+			return
+		}
+
+		onHold := false
+		for _, build := range builds {
+			onHold = onHold || build.Status == CiOnHold
+		}
+
+		if onHold && !state.OnHold {
+			logging.WithFields(fields).Info("build is waiting for an approval")
+			state.OnHold = true
+			notify(BuildStatusOnHold)
+		}
+
+		if done := greenJobs(builds); settings.ProgressNotifications && len(done) > len(state.DoneJobs) && len(done) < len(builds) {
+			logging.WithFields(fields).WithFields(logrus.Fields{"done": done}).Info("some jobs are green")
+			state.DoneJobs = done
+			notify(BuildStatusProgress)
+		}
+
+		missing := settings.missing(builds)
+		if len(missing) > 0 {
+			logging.WithFields(fields).WithFields(logrus.Fields{"missing": missing}).Info("required jobs have not started")
+		}
+
+		allGreen := len(missing) == 0
+		for _, build := range builds {
+			isGreen := build.Status == CiSuccess
 			allGreen = allGreen && isGreen
 			if !isGreen {
 				logging.WithFields(fields).WithFields(logrus.Fields{
-					"link":   build.BuildURL,
+					"link":   build.Url,
 					"status": build.Status,
 				}).Info("pending build or something")
 			}
 		}
 
-		if allGreensRestarts > allGreensWaitTimes {
-			logging.WithFields(fields).
-				WithFields(logrus.Fields{"restarts": allGreensRestarts}).
-				Warn("died waiting for green result")
+		if !allGreen {
+			if now.After(state.Deadline) {
+				logging.WithFields(fields).
+					WithFields(logrus.Fields{"restarts": state.Restarts, "deadline": state.Deadline}).
+					Warn("died waiting for green result")
 
-			event.BuildStatus = "wait_failed"
-			f(ctx, event)
-			return
-		}
+				finish(BuildStatusWaitFailed)
+				return
+			}
 
-		if !allGreen {
 			logging.WithFields(fields).
-				WithFields(logrus.Fields{"restarts": allGreensRestarts}).
+				WithFields(logrus.Fields{"restarts": state.Restarts}).
 				Info("some builds not green, restart")
-			allGreensRestarts += 1
-			greens = false
+			state.Restarts += 1
+			state.Greens = false
+			state.Phase = PhaseWaitingGreen
 			continue
 		}
 
-		if !greens {
-			greens = allGreen
+		if !state.Greens {
+			state.Greens = allGreen
+			state.Phase = PhaseConfirmingGreen
+			state.PollInterval = settings.PollInterval
 			logging.WithFields(fields).Info("all greens. restart once to make sure")
 		} else {
 			// successfully checked that all builds are green
 			logging.WithFields(fields).Info("build is green")
-			event.BuildStatus = "success"
-			f(ctx, event)
+			finish(BuildStatusSuccess)
 			return
 		}
 	}
 }
+
+// align moves the poll to the start of the next share window. The jitter spreads the polls
+// over much more than one window, aligned they still land together and share one fetch.
+func (s *ciMonitor) align(wait time.Duration) time.Duration {
+	if s.window <= 0 {
+		return wait
+	}
+
+	now := s.clock.Now()
+
+	return now.Add(wait).Truncate(s.window).Add(s.window).Sub(now)
+}
+
+// Deliver hands a webhook result to the monitors waiting for its commit and reports how many took it.
+func (s *ciMonitor) Deliver(result CiResult) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taken := 0
+	for _, rm := range s.running {
+		if !waitsFor(rm.state, result) {
+			continue
+		}
+
+		select {
+		case rm.results <- result:
+			taken += 1
+		default:
+		}
+	}
+
+	return taken
+}
+
+func waitsFor(state MonitorState, result CiResult) bool {
+	event := state.Event
+	if state.Provider != result.Provider {
+		return false
+	}
+
+	if !strings.EqualFold(event.Org, result.Org) || !strings.EqualFold(event.Repo, result.Repo) {
+		return false
+	}
+
+	if len(event.Sha) > 0 {
+		return event.Sha == result.Sha
+	}
+
+	return len(event.Tag) > 0 && event.Tag == result.Tag
+}
+
+// supersede cancels the older monitors watching the same branch.
+func (s *ciMonitor) supersede(newer MonitorState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rm := range s.running {
+		if !sameBranch(rm.state, newer) || !rm.state.StartedAt.Before(newer.StartedAt) {
+			continue
+		}
+
+		rm.reason = cancelSuperseded
+		rm.supersededBy = newer.Event.Sha
+		rm.cancel()
+	}
+}
+
+// newerOnBranch reports the commit of a monitor which started later on the same branch.
+func (s *ciMonitor) newerOnBranch(state MonitorState) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rm := range s.running {
+		if sameBranch(rm.state, state) && rm.state.StartedAt.After(state.StartedAt) {
+			return rm.state.Event.Sha, true
+		}
+	}
+
+	return "", false
+}
+
+func sameBranch(a, b MonitorState) bool {
+	return a.Id != b.Id &&
+		a.Event.Event == PullRequestMergedEvent &&
+		b.Event.Event == PullRequestMergedEvent &&
+		a.Event.Org == b.Event.Org &&
+		a.Event.Repo == b.Event.Repo &&
+		a.Event.BranchRef == b.Event.BranchRef
+}
+
+func (s *ciMonitor) register(state MonitorState, cancel context.CancelFunc) *runningMonitor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rm := &runningMonitor{state: state, cancel: cancel, results: make(chan CiResult, 16)}
+	s.running[state.Id] = rm
+
+	return rm
+}
+
+func (s *ciMonitor) unregister(id string, cancel context.CancelFunc) {
+	s.mu.Lock()
+	delete(s.running, id)
+	s.mu.Unlock()
+
+	cancel()
+}
+
+// update publishes the state to the registry and persists it, when it changed since the last save.
+// The poll time, the backoff and the poll counters change with every poll, a resumed monitor
+// does fine with older ones.
+func (s *ciMonitor) update(fields logrus.Fields, state MonitorState) {
+	compared := state
+	compared.PolledAt = time.Time{}
+	compared.PollInterval = 0
+	compared.Skips = 0
+	compared.Restarts = 0
+
+	bts, err := json.Marshal(compared)
+	if err != nil {
+		logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Error("persist monitor")
+		return
+	}
+
+	s.mu.Lock()
+	rm, ok := s.running[state.Id]
+	if ok {
+		rm.state = state
+		if bytes.Equal(rm.saved, bts) {
+			s.mu.Unlock()
+			return
+		}
+
+		rm.saved = bts
+	}
+	s.mu.Unlock()
+
+	s.save(fields, state)
+}
+
+func canRetry(retrier CiRetrier, state MonitorState, settings MonitorSettings) bool {
+	return retrier != nil && !state.RetryRefused && state.Retries < settings.Retries
+}
+
+// retry reruns the failed builds and remembers the replaced ones, so that the polls skip them.
+// Canceled builds are not flaky and fail the whole round. When the ci refuses to rerun a build,
+// the reruns already started are watched to the end and no more rounds follow.
+func (s *ciMonitor) retry(fields logrus.Fields, retrier CiRetrier, state *MonitorState, builds []Build) bool {
+	for _, build := range builds {
+		if build.Status == CiCanceled {
+			return false
+		}
+	}
+
+	retried := false
+	for _, build := range builds {
+		if build.Status != CiFailed {
+			continue
+		}
+
+		rerun, err := retrier.Retry(state.Event.Org, state.Event.Repo, build)
+		if err != nil {
+			logging.WithFields(fields).WithFields(logrus.Fields{"err": err, "build": build.Name}).Error("retry failed build")
+			state.RetryRefused = true
+			break
+		}
+
+		logging.WithFields(fields).WithFields(logrus.Fields{"build": build.Name, "retry": rerun.Url}).Info("retried failed build")
+		// builds without numbers are replaced by their reruns in the ci itself
+		if build.Number > 0 {
+			state.Retried = append(state.Retried, build.Number)
+		}
+		retried = true
+	}
+
+	return retried
+}
+
+// settling reports whether some of the builds have not finished yet.
+func settling(builds []Build) bool {
+	for _, build := range builds {
+		if build.Status == CiRunning || build.Status == CiPending {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withoutRetried(builds []Build, retried []int) []Build {
+	if len(retried) == 0 {
+		return builds
+	}
+
+	var ret []Build
+	for _, build := range builds {
+		if !containsInt(retried, build.Number) {
+			ret = append(ret, build)
+		}
+	}
+
+	return ret
+}
+
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// describeFailure puts the failed tests and the output tail of the failed builds into the event,
+// within the limits of the settings. Errors are only logged, the failure gets reported anyway.
+func (s *ciMonitor) describeFailure(fields logrus.Fields, provider CiProvider, event *Event, settings MonitorSettings, builds []Build) {
+	if settings.FailedTests <= 0 && settings.LogTailLines <= 0 {
+		return
+	}
+
+	cf, ok := provider.(CiFailures)
+	if !ok {
+		logging.WithFields(fields).WithFields(logrus.Fields{"provider": provider.Name()}).Warn("ci provider can not describe failures")
+		return
+	}
+
+	for _, build := range builds {
+		if build.Status != CiFailed {
+			continue
+		}
+
+		failure, err := cf.Failure(event.Org, event.Repo, build)
+		if err != nil {
+			logging.WithFields(fields).WithFields(logrus.Fields{"err": err, "build": build.Name}).Warn("describe failed build")
+			continue
+		}
+
+		event.FailedTests = append(event.FailedTests, failure.Tests...)
+		if len(event.LogTail) == 0 {
+			event.LogTail = logTail(failure.Log, settings.LogTailLines, settings.LogTailBytes)
+		}
+	}
+
+	if len(event.FailedTests) > settings.FailedTests {
+		event.MoreFailedTests = len(event.FailedTests) - settings.FailedTests
+		event.FailedTests = event.FailedTests[:settings.FailedTests]
+	}
+}
+
+// logTail keeps the last lines of the log, cut further to the last bytes when the lines are long.
+func logTail(log string, lines, bytes int) string {
+	if lines <= 0 {
+		return ""
+	}
+
+	log = strings.TrimRight(strings.Replace(log, "\r\n", "\n", -1), "\n")
+	all := strings.Split(log, "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+
+	tail := strings.Join(all, "\n")
+	if bytes > 0 && len(tail) > bytes {
+		// do not start in the middle of a rune
+		tail = strings.TrimLeftFunc(tail[len(tail)-bytes:], func(r rune) bool { return r == utf8.RuneError })
+	}
+
+	return tail
+}
+
+func jobResults(builds []Build, now time.Time) []JobResult {
+	jobs := make([]JobResult, 0, len(builds))
+	for _, build := range builds {
+		job := JobResult{
+			Name:      build.Name,
+			Status:    build.Status,
+			Url:       build.Url,
+			StartedAt: build.StartedAt,
+			StoppedAt: build.StoppedAt,
+		}
+
+		if build.StartedAt != nil {
+			stopped := now
+			if build.StoppedAt != nil {
+				stopped = *build.StoppedAt
+			}
+
+			job.Duration = stopped.Sub(*build.StartedAt)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs
+}
+
+func greenJobs(builds []Build) []string {
+	var done []string
+	for _, build := range builds {
+		if build.Status == CiSuccess {
+			done = append(done, build.Name)
+		}
+	}
+
+	return done
+}
+
+func summarizeStatuses(builds []Build) string {
+	if len(builds) == 0 {
+		return "not_found"
+	}
+
+	seen := map[string]bool{}
+	statuses := []string{}
+	for _, build := range builds {
+		if !seen[build.Status] {
+			seen[build.Status] = true
+			statuses = append(statuses, build.Status)
+		}
+	}
+
+	sort.Strings(statuses)
+
+	return strings.Join(statuses, ",")
+}
+
+func (s *ciMonitor) save(fields logrus.Fields, state MonitorState) {
+	if err := s.store.Put(state.Id, state, 0); err != nil {
+		logging.WithFields(fields).WithFields(logrus.Fields{"err": err}).Error("persist monitor")
+	}
+}
@@ -0,0 +1,1071 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kudrykv/services-deploy-monitor/app/config"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/kvstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock jumps forward by the requested duration instead of sleeping.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+
+	return ch
+}
+
+// scriptedProvider answers with the status the build has at the given time.
+type scriptedProvider struct {
+	clock  *fakeClock
+	status func(elapsed time.Duration) string
+	start  time.Time
+}
+
+func (c *scriptedProvider) Name() string {
+	return ProviderCircleCi
+}
+
+func (c *scriptedProvider) Builds(org, repo, branch, sha string, since time.Time) ([]Build, error) {
+	status := c.status(c.clock.Now().Sub(c.start))
+	if len(status) == 0 {
+		return nil, nil
+	}
+
+	return []Build{{Provider: ProviderCircleCi, Sha: sha, Status: status}}, nil
+}
+
+func newTestMonitor(t *testing.T, status func(time.Duration) string, overrides []MonitorOverride) (*ciMonitor, func()) {
+	dir, err := ioutil.TempDir("", "monitors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := kvstore.Open(filepath.Join(dir, "monitors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{now: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}
+	ci := &scriptedProvider{clock: clock, status: status, start: clock.now}
+
+	cm := config.Monitor{
+		PollTimeIntervalS:   10,
+		PollMaxIntervalS:    60,
+		PollBackoffPercent:  150,
+		BuildAppearTimeoutS: 300,
+		GreenTimeoutS:       3600,
+		Provider:            ProviderCircleCi,
+	}
+
+	s := NewCiMonitor(cm, overrides, nil, []CiProvider{ci}, store).(*ciMonitor)
+	s.clock = clock
+
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// newState is the state Monitor starts watching the event with.
+func newState(s *ciMonitor, id string, event Event) MonitorState {
+	now := s.clock.Now()
+	settings := s.settings(event)
+
+	return MonitorState{
+		Id:           id,
+		Event:        event,
+		Provider:     settings.Provider,
+		Phase:        PhaseSearching,
+		StartedAt:    now,
+		Deadline:     now.Add(settings.AppearTimeout),
+		PollInterval: settings.PollInterval,
+	}
+}
+
+// mergedToMaster is the event most of the tests watch.
+var mergedToMaster = Event{Event: PullRequestMergedEvent, Org: "org", Repo: "api", BranchRef: "master", Sha: "a"}
+
+// mergedAfter is mergedToMaster for another commit.
+func mergedAfter(sha string) Event {
+	event := mergedToMaster
+	event.Sha = sha
+
+	return event
+}
+
+// watchEvents watches the event to the end and returns every notification sent for it.
+func watchEvents(s *ciMonitor, event Event) []Event {
+	var events []Event
+	s.watch(context.Background(), newState(s, "test", event), func(ctx context.Context, e Event) {
+		events = append(events, e)
+	})
+
+	return events
+}
+
+func runMonitor(s *ciMonitor, event Event) string {
+	return lastEvent(watchEvents(s, event)).BuildStatus
+}
+
+func lastEvent(events []Event) Event {
+	if len(events) == 0 {
+		return Event{}
+	}
+
+	return events[len(events)-1]
+}
+
+func buildStatuses(events []Event) []string {
+	var statuses []string
+	for _, e := range events {
+		statuses = append(statuses, e.BuildStatus)
+	}
+
+	return statuses
+}
+
+func TestCiMonitorWaitsForLongBuild(t *testing.T) {
+	s, cleanup := newTestMonitor(t, func(elapsed time.Duration) string {
+		switch {
+		case elapsed < 2*time.Minute:
+			return ""
+		case elapsed < 40*time.Minute:
+			return CiRunning
+		default:
+			return CiSuccess
+		}
+	}, nil)
+	defer cleanup()
+
+	if status := runMonitor(s, mergedToMaster); status != BuildStatusSuccess {
+		t.Errorf("expected %s, got %s", BuildStatusSuccess, status)
+	}
+}
+
+func TestCiMonitorDeadlines(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   func(time.Duration) string
+		expected string
+	}{
+		{
+			name:     "build never appears",
+			status:   func(time.Duration) string { return "" },
+			expected: BuildStatusSearchFailed,
+		},
+		{
+			name:     "build never goes green",
+			status:   func(time.Duration) string { return CiRunning },
+			expected: BuildStatusWaitFailed,
+		},
+		{
+			name: "build fails",
+			status: func(elapsed time.Duration) string {
+				if elapsed < 5*time.Minute {
+					return CiRunning
+				}
+
+				return CiFailed
+			},
+			expected: BuildStatusBuildFailed,
+		},
+	}
+
+	for _, test := range tests {
+		s, cleanup := newTestMonitor(t, test.status, nil)
+
+		if status := runMonitor(s, mergedToMaster); status != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, status)
+		}
+
+		cleanup()
+	}
+}
+
+func TestCiMonitorAppliesOverrides(t *testing.T) {
+	status := func(elapsed time.Duration) string {
+		if elapsed < 90*time.Minute {
+			return CiRunning
+		}
+
+		return CiSuccess
+	}
+
+	overrides := []MonitorOverride{{
+		Org:      "org",
+		Settings: MonitorSettings{GreenTimeout: 2 * time.Hour},
+	}}
+
+	s, cleanup := newTestMonitor(t, status, overrides)
+	defer cleanup()
+
+	matching := mergedToMaster
+	if got := runMonitor(s, matching); got != BuildStatusSuccess {
+		t.Errorf("expected %s, got %s", BuildStatusSuccess, got)
+	}
+
+	other, cleanupOther := newTestMonitor(t, status, overrides)
+	defer cleanupOther()
+
+	notMatching := mergedToMaster
+	notMatching.Org = "other"
+	if got := runMonitor(other, notMatching); got != BuildStatusWaitFailed {
+		t.Errorf("expected %s, got %s", BuildStatusWaitFailed, got)
+	}
+}
+
+func TestMonitorSettingsBackoff(t *testing.T) {
+	ms := MonitorSettings{PollInterval: 10 * time.Second, PollMaxInterval: time.Minute, BackoffPercent: 200}
+
+	expected := []time.Duration{20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	interval := ms.PollInterval
+	for i, want := range expected {
+		interval = ms.backoff(interval)
+		if interval != want {
+			t.Errorf("step %d: expected %s, got %s", i, want, interval)
+		}
+	}
+}
+
+func TestSettingsForMergesOverrides(t *testing.T) {
+	defaults := MonitorSettings{PollInterval: 10 * time.Second, Retries: 1, ProgressNotifications: true}
+	overrides := []MonitorOverride{
+		{Branch: regexp.MustCompile("^master$"), Settings: MonitorSettings{Retries: 3}},
+		{Repo: regexp.MustCompile("^payments$"), Settings: MonitorSettings{PollInterval: 20 * time.Second}},
+		{Repo: regexp.MustCompile("^web$"), Cleared: []string{SettingRetries, SettingProgressNotifications}},
+	}
+
+	tests := []struct {
+		repo     string
+		branch   string
+		interval time.Duration
+		retries  int
+		progress bool
+	}{
+		{repo: "api", branch: "develop", interval: 10 * time.Second, retries: 1, progress: true},
+		{repo: "api", branch: "master", interval: 10 * time.Second, retries: 3, progress: true},
+		{repo: "payments", branch: "master", interval: 20 * time.Second, retries: 3, progress: true},
+		{repo: "web", branch: "master", interval: 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		ms := settingsFor(defaults, overrides, Event{Org: "org", Repo: test.repo, BranchRef: test.branch})
+		if ms.PollInterval != test.interval || ms.Retries != test.retries || ms.ProgressNotifications != test.progress {
+			t.Errorf("%s@%s: expected %s/%d/%v, got %s/%d/%v", test.repo, test.branch,
+				test.interval, test.retries, test.progress, ms.PollInterval, ms.Retries, ms.ProgressNotifications)
+		}
+	}
+}
+
+// webhookClock never fires on its own, so only webhooks move the monitor.
+type webhookClock struct {
+	fakeClock
+}
+
+func (c *webhookClock) After(d time.Duration) <-chan time.Time {
+	if d > 0 {
+		return make(chan time.Time)
+	}
+
+	return c.fakeClock.After(d)
+}
+
+func TestCiMonitorTakesWebhookResults(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   CiResult
+		expected string
+	}{
+		{
+			name:     "failed job",
+			result:   CiResult{Provider: ProviderCircleCi, Kind: CiResultJob, Org: "Org", Repo: "api", Sha: "a", Status: CiFailed},
+			expected: BuildStatusBuildFailed,
+		},
+		{
+			name:     "green workflow confirmed by a poll",
+			result:   CiResult{Provider: ProviderCircleCi, Kind: CiResultWorkflow, Org: "org", Repo: "api", Sha: "a", Status: CiSuccess},
+			expected: BuildStatusSuccess,
+		},
+	}
+
+	for _, test := range tests {
+		s, cleanup := newTestMonitor(t, func(time.Duration) string { return CiSuccess }, nil)
+		s.clock = &webhookClock{fakeClock{now: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+		done := make(chan string)
+		go func() {
+			done <- runMonitor(s, mergedToMaster)
+		}()
+
+		if s.Deliver(CiResult{Provider: ProviderCircleCi, Org: "org", Repo: "api", Sha: "b", Status: CiFailed}) > 0 {
+			t.Fatalf("%s: result for another commit has been taken", test.name)
+		}
+
+		for s.Deliver(test.result) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		if status := <-done; status != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, status)
+		}
+
+		cleanup()
+	}
+}
+
+func TestCiMonitorReportsOnHoldOnce(t *testing.T) {
+	s, cleanup := newTestMonitor(t, func(elapsed time.Duration) string {
+		if elapsed < 30*time.Minute {
+			return CiOnHold
+		}
+
+		return CiSuccess
+	}, nil)
+	defer cleanup()
+
+	statuses := buildStatuses(watchEvents(s, mergedToMaster))
+	if len(statuses) != 2 || statuses[0] != BuildStatusOnHold || statuses[1] != BuildStatusSuccess {
+		t.Errorf("unexpected notifications: %v", statuses)
+	}
+}
+
+// jobsProvider reports a fixed set of jobs.
+type jobsProvider struct {
+	builds []Build
+}
+
+func (c jobsProvider) Name() string {
+	return ProviderCircleCi
+}
+
+func (c jobsProvider) Builds(org, repo, branch, sha string, since time.Time) ([]Build, error) {
+	return c.builds, nil
+}
+
+func TestCiMonitorRequiredJobs(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings MonitorSettings
+		builds   []Build
+		expected string
+		failed   []string
+	}{
+		{
+			name:     "optional job fails",
+			settings: MonitorSettings{OptionalJobs: []string{"lint"}},
+			builds:   []Build{{Name: "build", Status: CiSuccess}, {Name: "lint", Status: CiFailed}},
+			expected: BuildStatusSuccess,
+		},
+		{
+			name:     "job out of the required ones fails",
+			settings: MonitorSettings{RequiredJobs: []string{"build"}},
+			builds:   []Build{{Name: "build", Status: CiSuccess}, {Name: "nightly", Status: CiCanceled}},
+			expected: BuildStatusSuccess,
+		},
+		{
+			name:     "required jobs fail",
+			settings: MonitorSettings{RequiredJobs: []string{"build", "test", "e2e"}},
+			builds:   []Build{{Name: "build", Status: CiSuccess}, {Name: "test", Status: CiFailed}, {Name: "e2e", Status: CiCanceled}},
+			expected: BuildStatusBuildFailed,
+			failed:   []string{"test", "e2e"},
+		},
+		{
+			name:     "required job never starts",
+			settings: MonitorSettings{RequiredJobs: []string{"build", "deploy"}},
+			builds:   []Build{{Name: "build", Status: CiSuccess}},
+			expected: BuildStatusWaitFailed,
+		},
+		{
+			name:     "every job counts without the lists",
+			builds:   []Build{{Name: "build", Status: CiSuccess}, {Name: "lint", Status: CiFailed}},
+			expected: BuildStatusBuildFailed,
+			failed:   []string{"lint"},
+		},
+	}
+
+	for _, test := range tests {
+		s, cleanup := newTestMonitor(t, nil, []MonitorOverride{{Org: "org", Settings: test.settings}})
+		s.providers[ProviderCircleCi] = jobsProvider{builds: test.builds}
+
+		got := lastEvent(watchEvents(s, mergedToMaster))
+		if got.BuildStatus != test.expected || strings.Join(got.FailedJobs, ",") != strings.Join(test.failed, ",") {
+			t.Errorf("%s: expected %s %v, got %s %v", test.name, test.expected, test.failed, got.BuildStatus, got.FailedJobs)
+		}
+
+		cleanup()
+	}
+}
+
+// stagedProvider runs a build job and then a deploy job.
+type stagedProvider struct {
+	clock *fakeClock
+	start time.Time
+}
+
+func (c *stagedProvider) Name() string {
+	return ProviderCircleCi
+}
+
+func (c *stagedProvider) Builds(org, repo, branch, sha string, since time.Time) ([]Build, error) {
+	elapsed := c.clock.Now().Sub(c.start)
+	builtAt := c.start.Add(5 * time.Minute)
+	deployedAt := c.start.Add(20 * time.Minute)
+
+	build := Build{Name: "build", Status: CiRunning, StartedAt: &c.start}
+	deploy := Build{Name: "deploy", Status: CiPending}
+
+	if elapsed >= 5*time.Minute {
+		build.Status, build.StoppedAt = CiSuccess, &builtAt
+		deploy.Status, deploy.StartedAt = CiRunning, &builtAt
+	}
+
+	if elapsed >= 20*time.Minute {
+		deploy.Status, deploy.StoppedAt = CiSuccess, &deployedAt
+	}
+
+	return []Build{build, deploy}, nil
+}
+
+func TestCiMonitorProgressNotifications(t *testing.T) {
+	tests := []struct {
+		name     string
+		progress bool
+		expected []string
+	}{
+		{name: "disabled", expected: []string{BuildStatusSuccess}},
+		{name: "enabled", progress: true, expected: []string{BuildStatusStarted, BuildStatusProgress, BuildStatusSuccess}},
+	}
+
+	for _, test := range tests {
+		s, cleanup := newTestMonitor(t, nil, []MonitorOverride{{Org: "org", Settings: MonitorSettings{ProgressNotifications: test.progress}}})
+		s.providers[ProviderCircleCi] = &stagedProvider{clock: s.clock.(*fakeClock), start: s.clock.Now()}
+
+		events := watchEvents(s, mergedToMaster)
+		statuses, last := buildStatuses(events), lastEvent(events)
+		if strings.Join(statuses, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, statuses)
+		}
+
+		if len(last.Jobs) != 2 || last.Jobs[0].Duration != 5*time.Minute || last.Jobs[1].Duration != 15*time.Minute {
+			t.Errorf("%s: unexpected jobs %+v", test.name, last.Jobs)
+		}
+
+		cleanup()
+	}
+}
+
+func TestLogTail(t *testing.T) {
+	log := "one\r\ntwo\r\nthree\r\nfour\r\n"
+
+	tests := []struct {
+		lines, bytes int
+		expected     string
+	}{
+		{lines: 2, expected: "three\nfour"},
+		{lines: 10, expected: "one\ntwo\nthree\nfour"},
+		{lines: 2, bytes: 6, expected: "e\nfour"},
+		{lines: 0, expected: ""},
+	}
+
+	for _, test := range tests {
+		if tail := logTail(log, test.lines, test.bytes); tail != test.expected {
+			t.Errorf("%d lines, %d bytes: expected %q, got %q", test.lines, test.bytes, test.expected, tail)
+		}
+	}
+
+	if tail := logTail("ошибка", 1, 5); tail != "ка" {
+		t.Errorf("expected whole runes, got %q", tail)
+	}
+}
+
+// describedProvider tells the same failure for every failed build.
+type describedProvider struct {
+	jobsProvider
+	failure Failure
+}
+
+func (c describedProvider) Failure(org, repo string, build Build) (Failure, error) {
+	return c.failure, nil
+}
+
+func TestCiMonitorDescribesFailure(t *testing.T) {
+	s, cleanup := newTestMonitor(t, nil, []MonitorOverride{{Org: "org", Settings: MonitorSettings{FailedTests: 3, LogTailLines: 1}}})
+	defer cleanup()
+
+	s.providers[ProviderCircleCi] = describedProvider{
+		jobsProvider: jobsProvider{builds: []Build{{Name: "unit", Status: CiFailed}, {Name: "e2e", Status: CiFailed}, {Name: "lint", Status: CiSuccess}}},
+		failure: Failure{
+			Tests: []FailedTest{{Name: "TestA"}, {Name: "TestB"}},
+			Log:   "compiling\nFAIL\n",
+		},
+	}
+
+	got := lastEvent(watchEvents(s, mergedToMaster))
+	if got.BuildStatus != BuildStatusBuildFailed || len(got.FailedTests) != 3 || got.MoreFailedTests != 1 || got.LogTail != "FAIL" {
+		t.Errorf("unexpected failure: %s %+v %d %q", got.BuildStatus, got.FailedTests, got.MoreFailedTests, got.LogTail)
+	}
+}
+
+// recordedFailures remembers the builds it was asked to describe.
+type recordedFailures struct {
+	jobsProvider
+	described []int
+}
+
+func (c *recordedFailures) Failure(org, repo string, build Build) (Failure, error) {
+	c.described = append(c.described, build.Number)
+	return Failure{}, nil
+}
+
+func TestCiMonitorDescribesOnlyLatestRuns(t *testing.T) {
+	s, cleanup := newTestMonitor(t, nil, []MonitorOverride{{Org: "org", Settings: MonitorSettings{FailedTests: 3}}})
+	defer cleanup()
+	s.clock = &webhookClock{fakeClock{now: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+	provider := &recordedFailures{jobsProvider: jobsProvider{builds: []Build{
+		{Name: "test", Status: CiFailed, Number: 2},
+		{Name: "test", Status: CiFailed, Number: 1},
+	}}}
+	s.providers[ProviderCircleCi] = provider
+
+	state := newState(s, "test", mergedToMaster)
+	state.Retried = []int{1}
+
+	done := startMonitor(s, state)
+	waitRunning(t, s, 1)
+	s.Deliver(CiResult{Provider: ProviderCircleCi, Kind: CiResultJob, Org: "org", Repo: "api", Sha: "a", Name: "test", Status: CiFailed})
+
+	if events := <-done; len(events) != 1 || events[0].BuildStatus != BuildStatusBuildFailed {
+		t.Fatalf("expected a failed build, got %v", events)
+	}
+
+	if len(provider.described) != 1 || provider.described[0] != 2 {
+		t.Errorf("expected only the rerun to be described, got %v", provider.described)
+	}
+}
+
+// flakyProvider fails the jobs, the retries end with the given statuses. A retry with an empty status fails.
+type flakyProvider struct {
+	jobs    []string
+	retries []string
+	builds  []Build
+}
+
+func (c *flakyProvider) Name() string {
+	return ProviderCircleCi
+}
+
+func (c *flakyProvider) Builds(org, repo, branch, sha string, since time.Time) ([]Build, error) {
+	if len(c.builds) == 0 {
+		for idx, job := range c.jobs {
+			c.builds = append(c.builds, Build{Name: job, Status: CiFailed, Number: 100 * (idx + 1)})
+		}
+	}
+
+	return c.builds, nil
+}
+
+func (c *flakyProvider) Retry(org, repo string, build Build) (Build, error) {
+	status := c.retries[0]
+	c.retries = c.retries[1:]
+	if len(status) == 0 {
+		return Build{}, errors.New("retry refused")
+	}
+
+	rerun := Build{Name: build.Name, Status: status, Number: build.Number + 1}
+	c.builds = append([]Build{rerun}, c.builds...)
+
+	return rerun, nil
+}
+
+func TestCiMonitorRetriesFailedBuilds(t *testing.T) {
+	tests := []struct {
+		name     string
+		jobs     []string
+		retries  int
+		outcomes []string
+		expected []string
+	}{
+		{name: "no retries", outcomes: []string{CiSuccess}, expected: []string{BuildStatusBuildFailed}},
+		{name: "retry refused", jobs: []string{"unit", "e2e"}, retries: 2, outcomes: []string{CiSuccess, ""}, expected: []string{BuildStatusRetried, BuildStatusBuildFailed}},
+		{name: "every job retried", jobs: []string{"unit", "e2e"}, retries: 1, outcomes: []string{CiSuccess, CiSuccess}, expected: []string{BuildStatusRetried, BuildStatusSuccess}},
+		{name: "passes on retry", retries: 2, outcomes: []string{CiSuccess}, expected: []string{BuildStatusRetried, BuildStatusSuccess}},
+		{name: "passes on second retry", retries: 2, outcomes: []string{CiFailed, CiSuccess}, expected: []string{BuildStatusRetried, BuildStatusRetried, BuildStatusSuccess}},
+		{name: "out of retries", retries: 1, outcomes: []string{CiFailed}, expected: []string{BuildStatusRetried, BuildStatusBuildFailed}},
+	}
+
+	for _, test := range tests {
+		s, cleanup := newTestMonitor(t, nil, []MonitorOverride{{Org: "org", Settings: MonitorSettings{Retries: test.retries}}})
+		if test.jobs == nil {
+			test.jobs = []string{"test"}
+		}
+
+		s.providers[ProviderCircleCi] = &flakyProvider{jobs: test.jobs, retries: test.outcomes}
+
+		events := watchEvents(s, mergedToMaster)
+		statuses, last := buildStatuses(events), lastEvent(events)
+		if strings.Join(statuses, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, statuses)
+		}
+
+		if last.Retries != len(test.expected)-1 || len(last.Jobs) != len(test.jobs) {
+			t.Errorf("%s: expected %d retries of %d jobs, got %d %+v", test.name, len(test.expected)-1, len(test.jobs), last.Retries, last.Jobs)
+		}
+
+		cleanup()
+	}
+
+	// the retries are ignored for a ci which can not rerun builds
+	s, cleanup := newTestMonitor(t, nil, []MonitorOverride{{Org: "org", Settings: MonitorSettings{Retries: 2}}})
+	defer cleanup()
+	s.providers[ProviderCircleCi] = jobsProvider{builds: []Build{{Name: "test", Status: CiFailed}}}
+
+	if status := runMonitor(s, mergedToMaster); status != BuildStatusBuildFailed {
+		t.Errorf("expected %s without a retrier, got %s", BuildStatusBuildFailed, status)
+	}
+}
+
+// slowRerunProvider keeps the reruns running for a few polls.
+type slowRerunProvider struct {
+	*flakyProvider
+	polls int
+}
+
+func (c *slowRerunProvider) Builds(org, repo, branch, sha string, since time.Time) ([]Build, error) {
+	builds, err := c.flakyProvider.Builds(org, repo, branch, sha, since)
+	c.polls += 1
+	if c.polls > 5 {
+		for idx := range builds {
+			if builds[idx].Status == CiRunning {
+				builds[idx].Status = CiSuccess
+			}
+		}
+	}
+
+	return builds, err
+}
+
+func TestCiMonitorWatchesStartedRerunsWhenRetryRefused(t *testing.T) {
+	s, cleanup := newTestMonitor(t, nil, []MonitorOverride{{Org: "org", Settings: MonitorSettings{Retries: 2}}})
+	defer cleanup()
+
+	provider := &slowRerunProvider{flakyProvider: &flakyProvider{jobs: []string{"unit", "e2e"}, retries: []string{CiRunning, ""}}}
+	s.providers[ProviderCircleCi] = provider
+
+	events := watchEvents(s, mergedToMaster)
+	statuses, last := buildStatuses(events), lastEvent(events)
+	if strings.Join(statuses, ",") != BuildStatusRetried+","+BuildStatusBuildFailed {
+		t.Fatalf("expected the failure after the retry, got %v", statuses)
+	}
+
+	if provider.polls <= 5 {
+		t.Errorf("expected the failure once the rerun settled, got it on poll %d", provider.polls)
+	}
+
+	for _, job := range last.Jobs {
+		if job.Status == CiRunning {
+			t.Errorf("expected no running jobs on failure, got %+v", last.Jobs)
+		}
+	}
+
+	if strings.Join(last.FailedJobs, ",") != "e2e" {
+		t.Errorf("expected only the refused job to fail, got %v", last.FailedJobs)
+	}
+}
+
+func TestCiMonitorResumesSavedMonitors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "monitors.json")
+	store, err := kvstore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{now: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cm := config.Monitor{PollTimeIntervalS: 10, BuildAppearTimeoutS: 300, GreenTimeoutS: 3600, Provider: ProviderCircleCi}
+
+	event := mergedToMaster
+	event.PrNumber = 7
+
+	before := NewCiMonitor(cm, nil, nil, nil, store).(*ciMonitor)
+	before.save(nil, MonitorState{
+		Id:           "saved",
+		RequestId:    "req",
+		Event:        event,
+		Provider:     ProviderCircleCi,
+		Phase:        PhaseConfirmingGreen,
+		Greens:       true,
+		StartedAt:    clock.now,
+		Deadline:     clock.now.Add(time.Hour),
+		PollInterval: 10 * time.Second,
+	})
+
+	// a restart opens the store from the file again
+	reopened, err := kvstore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := NewCiMonitor(cm, nil, nil, []CiProvider{jobsProvider{builds: []Build{{Name: "build", Status: CiSuccess}}}}, reopened).(*ciMonitor)
+	after.clock = clock
+
+	events := make(chan Event, 1)
+	after.Resume(context.Background(), func(ctx context.Context, e Event) {
+		events <- e
+	})
+
+	select {
+	case e := <-events:
+		// confirming already, one more green poll finishes it
+		if e.BuildStatus != BuildStatusSuccess || e.PrNumber != 7 || e.Source != ProviderCircleCi {
+			t.Errorf("unexpected event: %+v", e)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatal("resumed monitor did not finish")
+	}
+
+	waitForEmpty := time.Now().Add(time.Second)
+	for len(after.List()) > 0 || storedMonitors(t, reopened) > 0 {
+		if time.Now().After(waitForEmpty) {
+			t.Fatal("finished monitor was not forgotten")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func storedMonitors(t *testing.T, store *kvstore.Store) int {
+	count := 0
+	if err := store.Each(func(key string, value json.RawMessage) error {
+		count += 1
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+func TestCiMonitorSavesOnlyChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "monitors.json")
+	store, err := kvstore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewCiMonitor(config.Monitor{}, nil, nil, nil, store).(*ciMonitor)
+	state := MonitorState{Id: "m", Phase: PhaseSearching}
+	s.register(state, func() {})
+
+	s.update(nil, state)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the first update to persist: %v", err)
+	}
+
+	os.Remove(path)
+	s.update(nil, state)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected an unchanged state not to be written, got %v", err)
+	}
+
+	state.LastCiStatus = CiRunning
+	s.update(nil, state)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected a changed state to persist: %v", err)
+	}
+}
+
+// writesProvider counts the polls and tells the store writes apart by the file,
+// every write renames a new one into place.
+type writesProvider struct {
+	CiProvider
+	path   string
+	polls  int
+	writes int
+	last   os.FileInfo
+}
+
+func (c *writesProvider) Builds(org, repo, branch, sha string, since time.Time) ([]Build, error) {
+	c.polls += 1
+	if fi, err := os.Stat(c.path); err == nil && (c.last == nil || !os.SameFile(c.last, fi)) {
+		c.writes += 1
+		c.last = fi
+	}
+
+	return c.CiProvider.Builds(org, repo, branch, sha, since)
+}
+
+func TestCiMonitorSkipsWritesBetweenPolls(t *testing.T) {
+	s, cleanup := newTestMonitor(t, func(elapsed time.Duration) string {
+		if elapsed < 20*time.Minute {
+			return CiRunning
+		}
+
+		return CiSuccess
+	}, nil)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "monitors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "monitors.json")
+	if s.store, err = kvstore.Open(path); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &writesProvider{CiProvider: s.providers[ProviderCircleCi], path: path}
+	s.providers[ProviderCircleCi] = provider
+
+	if status := runMonitor(s, mergedToMaster); status != BuildStatusSuccess {
+		t.Fatalf("expected %s, got %s", BuildStatusSuccess, status)
+	}
+
+	// searching, waiting green and confirming green, the polls in between only move the poll time, the backoff and the counters
+	if provider.polls < 20 || provider.writes > 3 {
+		t.Errorf("expected at most 3 writes in %d polls, got %d", provider.polls, provider.writes)
+	}
+}
+
+// startMonitor watches the state in the background and hands out the reported events once it stops.
+func startMonitor(s *ciMonitor, state MonitorState) <-chan []Event {
+	done := make(chan []Event, 1)
+	go func() {
+		var events []Event
+		s.watch(context.Background(), state, func(ctx context.Context, e Event) {
+			events = append(events, e)
+		})
+		done <- events
+	}()
+
+	return done
+}
+
+// waitRunning waits until n monitors are registered.
+func waitRunning(t *testing.T, s *ciMonitor, n int) {
+	for i := 0; len(s.List()) != n; i++ {
+		if i == 1000 {
+			t.Fatalf("expected %d running monitors, got %d", n, len(s.List()))
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCiMonitorListsAndCancels(t *testing.T) {
+	s, cleanup := newTestMonitor(t, func(time.Duration) string { return CiRunning }, nil)
+	defer cleanup()
+	s.clock = &webhookClock{fakeClock{now: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+	first := newState(s, "first", mergedToMaster)
+	second := newState(s, "second", mergedAfter("b"))
+	second.StartedAt = first.StartedAt.Add(time.Minute)
+
+	silent := startMonitor(s, second)
+	notified := startMonitor(s, first)
+	waitRunning(t, s, 2)
+
+	list := s.List()
+	if list[0].Id != "first" || list[1].Id != "second" {
+		t.Errorf("expected monitors in start order, got %s, %s", list[0].Id, list[1].Id)
+	}
+
+	if state, ok := s.Get("first"); !ok || state.Event.Sha != "a" {
+		t.Errorf("expected the first monitor, got %v %v", state.Event.Sha, ok)
+	}
+
+	if _, ok := s.Get("unknown"); ok {
+		t.Error("unknown monitor found")
+	}
+
+	if err := s.Cancel("unknown", true); err != ErrMonitorNotFound {
+		t.Errorf("expected ErrMonitorNotFound, got %v", err)
+	}
+
+	if err := s.Cancel("first", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if events := <-notified; len(events) != 1 || events[0].BuildStatus != BuildStatusCanceled {
+		t.Errorf("expected a canceled event, got %v", events)
+	}
+
+	if err := s.Cancel("second", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if events := <-silent; len(events) != 0 {
+		t.Errorf("expected no events, got %v", events)
+	}
+
+	if len(s.List()) != 0 {
+		t.Errorf("expected no running monitors, got %d", len(s.List()))
+	}
+
+	if stored := storedMonitors(t, s.store); stored != 0 {
+		t.Errorf("expected canceled monitors to be removed from the store, got %d", stored)
+	}
+}
+
+func TestCiMonitorSupersedesOlderCommits(t *testing.T) {
+	tests := []struct {
+		name       string
+		branch     string
+		repo       string
+		superseded bool
+	}{
+		{name: "same branch", branch: "master", repo: "api", superseded: true},
+		{name: "other branch", branch: "develop", repo: "api"},
+		{name: "other repo", branch: "master", repo: "web"},
+	}
+
+	for _, test := range tests {
+		s, cleanup := newTestMonitor(t, func(time.Duration) string { return CiRunning }, nil)
+		s.clock = &webhookClock{fakeClock{now: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+		older := newState(s, "older", Event{Event: PullRequestMergedEvent, Org: "org", Repo: test.repo, BranchRef: test.branch, Sha: "a"})
+		newer := newState(s, "newer", mergedAfter("b"))
+		newer.StartedAt = older.StartedAt.Add(time.Minute)
+
+		done := startMonitor(s, older)
+		waitRunning(t, s, 1)
+
+		s.supersede(newer)
+
+		if !test.superseded {
+			if _, ok := s.Get("older"); !ok {
+				t.Errorf("%s: monitor has been superseded", test.name)
+			}
+
+			s.Cancel("older", false)
+		}
+
+		events := <-done
+		switch {
+		case test.superseded && (len(events) != 1 || events[0].BuildStatus != BuildStatusSuperseded || events[0].SupersededBy != "b"):
+			t.Errorf("%s: expected superseded by b, got %v", test.name, events)
+		case !test.superseded && len(events) != 0:
+			t.Errorf("%s: expected no events, got %v", test.name, events)
+		}
+
+		cleanup()
+	}
+}
+
+func TestCiMonitorCanceledByNewerCommit(t *testing.T) {
+	tests := []struct {
+		name     string
+		newer    bool
+		expected string
+	}{
+		{name: "newer commit", newer: true, expected: BuildStatusSuperseded},
+		{name: "no newer commit", expected: BuildStatusBuildFailed},
+	}
+
+	for _, test := range tests {
+		// the poll sees the canceled build
+		s, cleanup := newTestMonitor(t, func(time.Duration) string { return CiCanceled }, nil)
+		if test.newer {
+			newer := newState(s, "newer", mergedAfter("b"))
+			newer.StartedAt = newer.StartedAt.Add(time.Second)
+			s.register(newer, func() {})
+		}
+
+		if status := runMonitor(s, mergedToMaster); status != test.expected {
+			t.Errorf("%s: polled: expected %s, got %s", test.name, test.expected, status)
+		}
+
+		cleanup()
+
+		// the webhook reports the canceled workflow
+		s, cleanup = newTestMonitor(t, func(time.Duration) string { return CiRunning }, nil)
+		s.clock = &webhookClock{fakeClock{now: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+		done := startMonitor(s, newState(s, "test", mergedToMaster))
+		waitRunning(t, s, 1)
+
+		if test.newer {
+			newer := newState(s, "newer", mergedAfter("b"))
+			newer.StartedAt = newer.StartedAt.Add(time.Second)
+			s.register(newer, func() {})
+		}
+
+		s.Deliver(CiResult{Provider: ProviderCircleCi, Kind: CiResultWorkflow, Org: "org", Repo: "api", Sha: "a", Status: CiCanceled})
+
+		events := <-done
+		if len(events) != 1 || events[0].BuildStatus != test.expected {
+			t.Errorf("%s: webhook: expected %s, got %v", test.name, test.expected, events)
+		} else if test.newer && events[0].SupersededBy != "b" {
+			t.Errorf("%s: expected superseded by b, got %q", test.name, events[0].SupersededBy)
+		}
+
+		cleanup()
+	}
+}
+
+func TestCiMonitorAlignsPollsToShareWindow(t *testing.T) {
+	s, cleanup := newTestMonitor(t, func(time.Duration) string { return CiRunning }, nil)
+	defer cleanup()
+	s.clock = &fakeClock{now: time.Date(2019, 1, 1, 0, 0, 3, 0, time.UTC)}
+
+	if wait := s.align(55 * time.Second); wait != 55*time.Second {
+		t.Errorf("expected no alignment without a window, got %s", wait)
+	}
+
+	s.window = 8 * time.Second
+	tests := []struct {
+		wait     time.Duration
+		expected time.Duration
+	}{
+		{wait: 0, expected: 5 * time.Second},
+		{wait: 48 * time.Second, expected: 53 * time.Second},
+		{wait: 51 * time.Second, expected: 53 * time.Second},
+		{wait: 53 * time.Second, expected: 61 * time.Second},
+	}
+
+	for _, test := range tests {
+		if wait := s.align(test.wait); wait != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.wait, test.expected, wait)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package service
 
 import (
+	"errors"
+	"fmt"
 	"github.com/kudrykv/go-circleci"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -34,18 +39,87 @@ func NewCircleCi(token string) CircleCi {
 	}
 }
 
-func (s *circleCi) BuildsForProjectMatching(org, repo, branch, shaOrTag string) ([]circleci.Build, error) {
-	builds, err := s.client.ListRecentBuildsForProject(org, repo, branch, "", 30, 0)
+const (
+	// circleCiPageSize is the most builds the v1 api returns at once
+	circleCiPageSize = 100
+	// circleCiMaxDownload bounds the step outputs and artifacts read into memory
+	circleCiMaxDownload = 8 << 20
+)
+
+var ErrDownloadTooLarge = errors.New("download is too large")
+
+func (s *circleCi) RecentBuilds(org, repo, branch string, offset int) ([]circleci.Build, error) {
+	builds, err := s.client.ListRecentBuildsForProject(org, repo, branch, "", circleCiPageSize, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]circleci.Build, 0, len(builds))
+	for idx := range builds {
+		ret = append(ret, *builds[idx])
+	}
+
+	return ret, nil
+}
+
+func (s *circleCi) Build(org, repo string, num int) (*circleci.Build, error) {
+	return s.client.GetBuild(org, repo, num)
+}
+
+func (s *circleCi) Artifacts(org, repo string, num int) ([]circleci.Artifact, error) {
+	artifacts, err := s.client.ListBuildArtifacts(org, repo, num)
 	if err != nil {
 		return nil, err
 	}
 
-	var ret []circleci.Build
-	for idx, build := range builds {
-		if build.VcsRevision == shaOrTag || build.VcsTag == shaOrTag {
-			ret = append(ret, *builds[idx])
-		}
+	ret := make([]circleci.Artifact, 0, len(artifacts))
+	for idx := range artifacts {
+		ret = append(ret, *artifacts[idx])
 	}
 
 	return ret, nil
 }
+
+func (s *circleCi) Retry(org, repo string, num int) (*circleci.Build, error) {
+	return s.client.RetryBuild(org, repo, num)
+}
+
+// Download sends the token only to circleci itself, artifacts may be served by third party storage.
+func (s *circleCi) Download(url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if circleCiHost(req.URL.Hostname()) {
+		req.Header.Set("Circle-Token", s.client.Token)
+	}
+
+	resp, err := s.client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, circleCiMaxDownload+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("circleci responded with %d", resp.StatusCode)
+	}
+
+	if len(body) > circleCiMaxDownload {
+		return nil, ErrDownloadTooLarge
+	}
+
+	return body, nil
+}
+
+func circleCiHost(host string) bool {
+	host = strings.ToLower(host)
+
+	return host == "circleci.com" || strings.HasSuffix(host, ".circleci.com")
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"expvar"
+	"github.com/kudrykv/go-circleci"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	pollFetches = expvar.NewInt("circleci_poll_fetches")
+	pollShared  = expvar.NewInt("circleci_poll_shared")
+)
+
+// circleCiPoller makes one ListRecentBuildsForProject call per project, branch and page
+// in the share window and hands the result to every monitor asking for it.
+type circleCiPoller struct {
+	ci    CircleCi
+	polls *sharedPolls
+}
+
+// sharedPolls runs one fetch per key in the share window, the callers asking in the meantime get its result.
+type sharedPolls struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	polls map[string]*projectPoll
+}
+
+type projectPoll struct {
+	done      chan struct{}
+	fetchedAt time.Time
+	value     interface{}
+	err       error
+}
+
+func NewCircleCiPoller(ci CircleCi, window time.Duration) CircleCi {
+	return &circleCiPoller{
+		ci:    ci,
+		polls: newSharedPolls(window),
+	}
+}
+
+func newSharedPolls(window time.Duration) *sharedPolls {
+	return &sharedPolls{
+		window: window,
+		polls:  map[string]*projectPoll{},
+	}
+}
+
+func (s *circleCiPoller) RecentBuilds(org, repo, branch string, offset int) ([]circleci.Build, error) {
+	key := org + "/" + repo + "@" + branch + "+" + strconv.Itoa(offset)
+
+	value, err := s.polls.fetch(key, func() (interface{}, error) {
+		return s.ci.RecentBuilds(org, repo, branch, offset)
+	})
+
+	builds, _ := value.([]circleci.Build)
+
+	return builds, err
+}
+
+// Build, Artifacts, Retry and Download are asked for once per failed build, there is nothing to share.
+func (s *circleCiPoller) Build(org, repo string, num int) (*circleci.Build, error) {
+	return s.ci.Build(org, repo, num)
+}
+
+func (s *circleCiPoller) Artifacts(org, repo string, num int) ([]circleci.Artifact, error) {
+	return s.ci.Artifacts(org, repo, num)
+}
+
+func (s *circleCiPoller) Retry(org, repo string, num int) (*circleci.Build, error) {
+	return s.ci.Retry(org, repo, num)
+}
+
+func (s *circleCiPoller) Download(url string) ([]byte, error) {
+	return s.ci.Download(url)
+}
+
+// fetch hands out the result fetched for the key in the window, or fetches it.
+// The value is shared between the callers and must not be changed.
+func (s *sharedPolls) fetch(key string, f func() (interface{}, error)) (interface{}, error) {
+	s.mu.Lock()
+	if poll, ok := s.polls[key]; ok && (poll.fetchedAt.IsZero() || time.Since(poll.fetchedAt) < s.window) {
+		s.mu.Unlock()
+
+		<-poll.done
+		pollShared.Add(1)
+
+		return poll.value, poll.err
+	}
+
+	poll := &projectPoll{done: make(chan struct{})}
+	s.polls[key] = poll
+	s.mu.Unlock()
+
+	poll.value, poll.err = f()
+	pollFetches.Add(1)
+
+	s.mu.Lock()
+	if poll.err != nil {
+		// do not hand out the error for the whole window
+		delete(s.polls, key)
+	} else {
+		poll.fetchedAt = time.Now()
+	}
+	s.cleanup()
+	s.mu.Unlock()
+
+	close(poll.done)
+
+	return poll.value, poll.err
+}
+
+// cleanup drops the stale polls so that the map does not grow with every project ever seen.
+func (s *sharedPolls) cleanup() {
+	for key, poll := range s.polls {
+		if !poll.fetchedAt.IsZero() && time.Since(poll.fetchedAt) >= s.window {
+			delete(s.polls, key)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"github.com/kudrykv/go-circleci"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingCircleCi struct {
+	CircleCi
+	calls int32
+}
+
+func (c *countingCircleCi) RecentBuilds(org, repo, branch string, offset int) ([]circleci.Build, error) {
+	atomic.AddInt32(&c.calls, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	return []circleci.Build{{VcsRevision: "a"}, {VcsRevision: "b"}}, nil
+}
+
+func TestCircleCiPollerSharesFetches(t *testing.T) {
+	ci := &countingCircleCi{}
+	poller := NewCircleCiPoller(ci, time.Minute)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			builds, err := poller.RecentBuilds("org", "api", "master", 0)
+			if err != nil || len(builds) != 2 {
+				t.Errorf("unexpected result: %v %v", builds, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	poller.RecentBuilds("org", "web", "master", 0)
+
+	if calls := atomic.LoadInt32(&ci.calls); calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestCircleCiPollerRefetchesAfterWindow(t *testing.T) {
+	ci := &countingCircleCi{}
+	poller := NewCircleCiPoller(ci, time.Millisecond)
+
+	poller.RecentBuilds("org", "api", "master", 0)
+	time.Sleep(5 * time.Millisecond)
+	poller.RecentBuilds("org", "api", "master", 0)
+
+	if calls := atomic.LoadInt32(&ci.calls); calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kudrykv/go-circleci"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ansiEscape matches the color codes of the step output
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+
+type circleCiProvider struct {
+	ci       CircleCi
+	maxPages int
+}
+
+// NewCircleCiProvider looks up the builds in the recent builds of the project, one build per job.
+// It pages back until the builds get older than the commit, but no deeper than maxPages.
+func NewCircleCiProvider(ci CircleCi, maxPages int) CiProvider {
+	return &circleCiProvider{
+		ci:       ci,
+		maxPages: maxPages,
+	}
+}
+
+func (s *circleCiProvider) Name() string {
+	return ProviderCircleCi
+}
+
+func (s *circleCiProvider) Builds(org, repo, branch, shaOrTag string, since time.Time) ([]Build, error) {
+	var ret []Build
+	// new builds push the listed ones to the next page while paging
+	seen := map[int]bool{}
+
+	for page := 0; page < s.maxPages; page++ {
+		builds, err := s.ci.RecentBuilds(org, repo, branch, page*circleCiPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, build := range matchBuilds(builds, shaOrTag) {
+			if seen[build.BuildNum] {
+				continue
+			}
+			seen[build.BuildNum] = true
+
+			ret = append(ret, circleCiBuild(build))
+		}
+
+		last := len(builds) - 1
+		if last < circleCiPageSize-1 || startedBefore(builds[last], since) {
+			break
+		}
+
+		// without the commit time the builds of the commit end where the page stops matching
+		if since.IsZero() && len(ret) > 0 && len(matchBuilds(builds[last:], shaOrTag)) == 0 {
+			break
+		}
+	}
+
+	return ret, nil
+}
+
+// Failure reads the output of the failed step and the failed tests of the junit reports stored as artifacts.
+// Artifacts which fail to download or do not parse as junit get skipped.
+func (s *circleCiProvider) Failure(org, repo string, build Build) (Failure, error) {
+	var failure Failure
+
+	details, err := s.ci.Build(org, repo, build.Number)
+	if err != nil {
+		return failure, err
+	}
+
+	if action := failedAction(details); action != nil && len(action.OutputURL) > 0 {
+		body, err := s.ci.Download(action.OutputURL)
+		if err != nil {
+			return failure, err
+		}
+
+		var output []struct {
+			Message string `json:"message"`
+		}
+
+		if err := json.Unmarshal(body, &output); err != nil {
+			return failure, err
+		}
+
+		var log strings.Builder
+		for _, line := range output {
+			log.WriteString(line.Message)
+		}
+
+		failure.Log = ansiEscape.ReplaceAllString(log.String(), "")
+	}
+
+	artifacts, err := s.ci.Artifacts(org, repo, build.Number)
+	if err != nil {
+		return failure, err
+	}
+
+	for _, artifact := range artifacts {
+		if !strings.HasSuffix(artifact.Path, ".xml") {
+			continue
+		}
+
+		body, err := s.ci.Download(artifact.URL)
+		if err != nil {
+			continue
+		}
+
+		tests, err := parseJUnit(build.Name, body)
+		if err != nil {
+			continue
+		}
+
+		failure.Tests = append(failure.Tests, tests...)
+	}
+
+	return failure, nil
+}
+
+// Retry reruns the failed job, the retry is a new build with its own number.
+func (s *circleCiProvider) Retry(org, repo string, build Build) (Build, error) {
+	retried, err := s.ci.Retry(org, repo, build.Number)
+	if err != nil {
+		return Build{}, err
+	}
+
+	if retried == nil {
+		return Build{}, errors.New("circleci did not return the retried build")
+	}
+
+	return circleCiBuild(*retried), nil
+}
+
+// failedAction finds the first failed action of the build, parallel runs of a step are actions too.
+func failedAction(build *circleci.Build) *circleci.Action {
+	if build == nil {
+		return nil
+	}
+
+	for _, step := range build.Steps {
+		for _, action := range step.Actions {
+			if action.Failed != nil && *action.Failed || action.Status == "failed" || action.Status == "timedout" {
+				return action
+			}
+		}
+	}
+
+	return nil
+}
+
+// startedBefore reports whether the build is older than the time, builds are listed newest first.
+func startedBefore(build circleci.Build, since time.Time) bool {
+	if since.IsZero() {
+		return false
+	}
+
+	started := build.StartTime
+	if started == nil {
+		started = build.CommitterDate
+	}
+
+	return started != nil && started.Before(since)
+}
+
+func matchBuilds(builds []circleci.Build, shaOrTag string) []circleci.Build {
+	var ret []circleci.Build
+	for idx, build := range builds {
+		if build.VcsRevision == shaOrTag || build.VcsTag == shaOrTag {
+			ret = append(ret, builds[idx])
+		}
+	}
+
+	return ret
+}
+
+func circleCiBuild(build circleci.Build) Build {
+	name := "build " + strconv.Itoa(build.BuildNum)
+	if build.Workflows != nil && len(build.Workflows.JobName) > 0 {
+		name = build.Workflows.JobName
+	}
+
+	return Build{
+		Provider:  ProviderCircleCi,
+		Name:      name,
+		Status:    circleCiStatus(build.Status),
+		Url:       build.BuildURL,
+		Sha:       build.VcsRevision,
+		Branch:    build.Branch,
+		Tag:       build.VcsTag,
+		StartedAt: build.StartTime,
+		StoppedAt: build.StopTime,
+		Number:    build.BuildNum,
+	}
+}
+
+// circleCiStatus maps the statuses of circleci builds, workflows and jobs.
+func circleCiStatus(status string) string {
+	switch status {
+	case "success", "fixed", "no_tests":
+		return CiSuccess
+
+	case "failed", "error", "failing", "infrastructure_fail", "timedout", "unauthorized":
+		return CiFailed
+
+	case "canceled":
+		return CiCanceled
+
+	case "running":
+		return CiRunning
+
+	case "on_hold":
+		return CiOnHold
+
+	default:
+		return CiPending
+	}
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"github.com/kudrykv/go-circleci"
+	"testing"
+	"time"
+)
+
+type fixedCircleCi struct {
+	CircleCi
+	builds []circleci.Build
+}
+
+func (c fixedCircleCi) RecentBuilds(org, repo, branch string, offset int) ([]circleci.Build, error) {
+	return c.builds, nil
+}
+
+func TestCircleCiProviderMapsBuilds(t *testing.T) {
+	provider := NewCircleCiProvider(fixedCircleCi{builds: []circleci.Build{
+		{VcsRevision: "a", BuildNum: 1, Status: "fixed"},
+		{VcsRevision: "a", BuildNum: 2, Status: "infrastructure_fail", Workflows: &circleci.Workflow{JobName: "test"}},
+		{VcsRevision: "a", BuildNum: 3, Status: "queued"},
+		{VcsRevision: "b", BuildNum: 4, Status: "canceled"},
+		{VcsTag: "release-1", BuildNum: 5, Status: "canceled"},
+	}}, 10)
+
+	builds, err := provider.Builds("org", "api", "master", "a", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Build{
+		{Provider: ProviderCircleCi, Name: "build 1", Status: CiSuccess, Sha: "a", Number: 1},
+		{Provider: ProviderCircleCi, Name: "test", Status: CiFailed, Sha: "a", Number: 2},
+		{Provider: ProviderCircleCi, Name: "build 3", Status: CiPending, Sha: "a", Number: 3},
+	}
+
+	if len(builds) != len(expected) {
+		t.Fatalf("expected %d builds, got %d", len(expected), len(builds))
+	}
+
+	for i := range expected {
+		if builds[i] != expected[i] {
+			t.Errorf("build %d: expected %+v, got %+v", i, expected[i], builds[i])
+		}
+	}
+
+	tagged, _ := provider.Builds("org", "api", "", "release-1", time.Time{})
+	if len(tagged) != 1 || tagged[0].Status != CiCanceled {
+		t.Errorf("unexpected tagged builds: %+v", tagged)
+	}
+}
+
+// pagedCircleCi lists builds numbered from the newest, one started every minute.
+type pagedCircleCi struct {
+	CircleCi
+	latest time.Time
+	total  int
+	hits   map[int]circleci.Build
+	calls  int
+	// shift is how many builds start between two calls, they push the listed builds down
+	shift int
+}
+
+func (c *pagedCircleCi) RecentBuilds(org, repo, branch string, offset int) ([]circleci.Build, error) {
+	offset -= c.shift * c.calls
+	c.calls += 1
+
+	var builds []circleci.Build
+	for i := offset; i < offset+circleCiPageSize && i < c.total; i++ {
+		build, ok := c.hits[i]
+		if !ok {
+			build = circleci.Build{VcsRevision: "other", Status: "success"}
+		}
+
+		started := c.latest.Add(-time.Duration(i) * time.Minute)
+		build.BuildNum = c.total - i
+		build.StartTime = &started
+		builds = append(builds, build)
+	}
+
+	return builds, nil
+}
+
+func TestCircleCiProviderPagesBack(t *testing.T) {
+	latest := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		since    time.Time
+		hits     map[int]circleci.Build
+		shaOrTag string
+		shift    int
+		builds   int
+		calls    int
+	}{
+		{
+			name:     "commit deep in the history",
+			since:    latest.Add(-260 * time.Minute),
+			hits:     map[int]circleci.Build{250: {VcsRevision: "a"}, 251: {VcsRevision: "a"}},
+			shaOrTag: "a",
+			builds:   2,
+			calls:    3,
+		},
+		{
+			name:     "no builds for the commit yet",
+			since:    latest.Add(-30 * time.Minute),
+			shaOrTag: "a",
+			calls:    1,
+		},
+		{
+			name:     "release tag on the second page",
+			since:    latest.Add(-150 * time.Minute),
+			hits:     map[int]circleci.Build{120: {VcsRevision: "b", VcsTag: "release-1"}},
+			shaOrTag: "release-1",
+			builds:   1,
+			calls:    2,
+		},
+		{
+			name:     "unknown commit time stops at the first hit",
+			hits:     map[int]circleci.Build{120: {VcsRevision: "a"}},
+			shaOrTag: "a",
+			builds:   1,
+			calls:    2,
+		},
+		{
+			name:     "unknown commit time follows the builds to the next page",
+			hits:     map[int]circleci.Build{98: {VcsRevision: "a"}, 99: {VcsRevision: "a"}, 100: {VcsRevision: "a"}},
+			shaOrTag: "a",
+			builds:   3,
+			calls:    2,
+		},
+		{
+			name:     "builds pushed to the next page are counted once",
+			hits:     map[int]circleci.Build{98: {VcsRevision: "a"}, 99: {VcsRevision: "a"}},
+			shaOrTag: "a",
+			shift:    5,
+			builds:   2,
+			calls:    2,
+		},
+		{
+			name:     "unknown commit time stays within the max pages",
+			shaOrTag: "a",
+			calls:    4,
+		},
+	}
+
+	for _, test := range tests {
+		ci := &pagedCircleCi{latest: latest, total: 1000, hits: test.hits, shift: test.shift}
+		builds, err := NewCircleCiProvider(ci, 4).Builds("org", "api", "", test.shaOrTag, test.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(builds) != test.builds || ci.calls != test.calls {
+			t.Errorf("%s: expected %d builds in %d calls, got %d in %d", test.name, test.builds, test.calls, len(builds), ci.calls)
+		}
+	}
+}
+
+// failedCircleCi has a build with a failed step and a junit report among the artifacts.
+type failedCircleCi struct {
+	CircleCi
+	files map[string]string
+}
+
+func (c failedCircleCi) Build(org, repo string, num int) (*circleci.Build, error) {
+	failed := true
+
+	return &circleci.Build{BuildNum: num, Steps: []*circleci.Step{
+		{Name: "checkout", Actions: []*circleci.Action{{Status: "success", OutputURL: "https://out/0"}}},
+		{Name: "test", Actions: []*circleci.Action{{Status: "failed", Failed: &failed, OutputURL: "https://out/1"}}},
+	}}, nil
+}
+
+func (c failedCircleCi) Artifacts(org, repo string, num int) ([]circleci.Artifact, error) {
+	return []circleci.Artifact{
+		{Path: "coverage.html", URL: "https://art/coverage.html"},
+		{Path: "reports/junit.xml", URL: "https://art/junit.xml"},
+	}, nil
+}
+
+func (c failedCircleCi) Download(url string) ([]byte, error) {
+	return []byte(c.files[url]), nil
+}
+
+func TestCircleCiProviderDescribesFailure(t *testing.T) {
+	provider := NewCircleCiProvider(failedCircleCi{files: map[string]string{
+		"https://out/1":         `[{"message":"go test ./...\r\n"},{"message":"\u001b[31mFAIL\u001b[0m api\r\n"}]`,
+		"https://art/junit.xml": `<testsuite name="api"><testcase name="TestFails"><failure message="boom"/></testcase></testsuite>`,
+	}}, 10).(CiFailures)
+
+	failure, err := provider.Failure("org", "api", Build{Name: "test", Number: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if failure.Log != "go test ./...\r\nFAIL api\r\n" {
+		t.Errorf("unexpected log %q", failure.Log)
+	}
+
+	if len(failure.Tests) != 1 || failure.Tests[0] != (FailedTest{Job: "test", Suite: "api", Name: "TestFails", Message: "boom"}) {
+		t.Errorf("unexpected tests %+v", failure.Tests)
+	}
+}
+
+type retryingCircleCi struct {
+	CircleCi
+	retried int
+}
+
+func (c *retryingCircleCi) Retry(org, repo string, num int) (*circleci.Build, error) {
+	c.retried = num
+
+	return &circleci.Build{BuildNum: 12, VcsRevision: "a", Status: "queued", Workflows: &circleci.Workflow{JobName: "test"}}, nil
+}
+
+func TestCircleCiProviderRetries(t *testing.T) {
+	ci := &retryingCircleCi{}
+	provider := NewCircleCiProvider(ci, 10).(CiRetrier)
+
+	rerun, err := provider.Retry("org", "api", Build{Name: "test", Number: 11})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ci.retried != 11 || rerun.Number != 12 || rerun.Name != "test" || rerun.Status != CiPending {
+		t.Errorf("unexpected retry of %d: %+v", ci.retried, rerun)
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCircleCiDownloadKeepsTokenToCircleCi(t *testing.T) {
+	var token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("Circle-Token")
+		w.Write([]byte("report"))
+	}))
+	defer server.Close()
+
+	body, err := NewCircleCi("secret").Download(server.URL + "/junit.xml")
+	if err != nil || string(body) != "report" {
+		t.Fatalf("unexpected download: %q %v", body, err)
+	}
+
+	if len(token) > 0 {
+		t.Errorf("token sent to a third party host: %q", token)
+	}
+
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{"circleci.com", true},
+		{"CircleCI.com", true},
+		{"output.circleci.com", true},
+		{"circle-production-action-output.s3.amazonaws.com", false},
+		{"circleci.com.example.org", false},
+		{"notcircleci.com", false},
+	}
+
+	for _, test := range tests {
+		if got := circleCiHost(test.host); got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.host, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	circleCiV2Url = "https://circleci.com/api/v2"
+	// pipelinePages bounds the search for the pipeline of an older commit
+	pipelinePages = 5
+)
+
+type circleCiV2 struct {
+	url    string
+	token  string
+	client *http.Client
+	polls  *sharedPolls
+}
+
+type pipelinePage struct {
+	Items         []pipeline `json:"items"`
+	NextPageToken string     `json:"next_page_token"`
+}
+
+type pipeline struct {
+	Id        string    `json:"id"`
+	Number    int       `json:"number"`
+	CreatedAt time.Time `json:"created_at"`
+	Vcs       struct {
+		Revision string `json:"revision"`
+		Branch   string `json:"branch"`
+		Tag      string `json:"tag"`
+	} `json:"vcs"`
+}
+
+type workflowPage struct {
+	Items []struct {
+		Id        string     `json:"id"`
+		Name      string     `json:"name"`
+		Status    string     `json:"status"`
+		CreatedAt *time.Time `json:"created_at"`
+		StoppedAt *time.Time `json:"stopped_at"`
+	} `json:"items"`
+}
+
+type workflowJobPage struct {
+	Items []struct {
+		Name      string `json:"name"`
+		JobNumber int    `json:"job_number"`
+		Status    string `json:"status"`
+	} `json:"items"`
+}
+
+type testsPage struct {
+	Items []struct {
+		Name      string `json:"name"`
+		Classname string `json:"classname"`
+		Result    string `json:"result"`
+		Message   string `json:"message"`
+	} `json:"items"`
+}
+
+// NewCircleCiV2 looks up the pipelines of the commit and reports every workflow in them as a build.
+// The pipelines and workflows fetched in the share window are shared between the monitors.
+func NewCircleCiV2(token string, window time.Duration) CiProvider {
+	return &circleCiV2{
+		url:   circleCiV2Url,
+		token: token,
+		polls: newSharedPolls(window),
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: http.RoundTripper(&http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+					DualStack: true,
+				}).DialContext,
+				MaxIdleConns:          10,
+				IdleConnTimeout:       90 * time.Second,
+				TLSHandshakeTimeout:   5 * time.Second,
+				ExpectContinueTimeout: 1 * time.Second,
+			}),
+		},
+	}
+}
+
+func (s *circleCiV2) Name() string {
+	return ProviderCircleCi
+}
+
+func (s *circleCiV2) Builds(org, repo, branch, shaOrTag string, since time.Time) ([]Build, error) {
+	slug := "gh/" + org + "/" + repo
+
+	query := url.Values{}
+	if len(branch) > 0 {
+		query.Set("branch", branch)
+	}
+
+	var builds []Build
+	seen := map[string]bool{}
+	found := false
+
+	for page := 0; page < pipelinePages; page++ {
+		var pipelines pipelinePage
+		if err := s.get("/project/"+slug+"/pipeline?"+query.Encode(), &pipelines); err != nil {
+			return nil, err
+		}
+
+		// newest first, so the workflows of a re-triggered pipeline win over the old ones
+		for _, pipeline := range pipelines.Items {
+			if !pipeline.of(shaOrTag) {
+				continue
+			}
+
+			found = true
+
+			var workflows workflowPage
+			if err := s.get("/pipeline/"+pipeline.Id+"/workflow", &workflows); err != nil {
+				return nil, err
+			}
+
+			for _, workflow := range workflows.Items {
+				// a rerun adds a workflow with the same name, the newest one counts
+				if seen[workflow.Name] {
+					continue
+				}
+				seen[workflow.Name] = true
+
+				builds = append(builds, Build{
+					Provider:  ProviderCircleCi,
+					Name:      workflow.Name,
+					Status:    circleCiStatus(workflow.Status),
+					Url:       fmt.Sprintf("https://app.circleci.com/pipelines/%s/%d/workflows/%s", slug, pipeline.Number, workflow.Id),
+					Sha:       pipeline.Vcs.Revision,
+					Branch:    pipeline.Vcs.Branch,
+					Tag:       pipeline.Vcs.Tag,
+					StartedAt: workflow.CreatedAt,
+					StoppedAt: workflow.StoppedAt,
+					Id:        workflow.Id,
+				})
+			}
+		}
+
+		// the pipelines of the commit are next to each other, the next page may hold more of them
+		last := len(pipelines.Items) - 1
+		if len(pipelines.NextPageToken) == 0 || last < 0 ||
+			!since.IsZero() && pipelines.Items[last].CreatedAt.Before(since) ||
+			found && !pipelines.Items[last].of(shaOrTag) {
+			break
+		}
+
+		query.Set("page-token", pipelines.NextPageToken)
+	}
+
+	return builds, nil
+}
+
+// Failure lists the failed tests of the failed jobs in the workflow. The v2 api has no step output,
+// so the log stays empty.
+func (s *circleCiV2) Failure(org, repo string, build Build) (Failure, error) {
+	var failure Failure
+	if len(build.Id) == 0 {
+		return failure, errors.New("build has no workflow id")
+	}
+
+	var jobs workflowJobPage
+	if err := s.request("GET", "/workflow/"+build.Id+"/job", nil, &jobs); err != nil {
+		return failure, err
+	}
+
+	for _, job := range jobs.Items {
+		if job.Status != "failed" || job.JobNumber == 0 {
+			continue
+		}
+
+		var tests testsPage
+		if err := s.request("GET", fmt.Sprintf("/project/gh/%s/%s/%d/tests", org, repo, job.JobNumber), nil, &tests); err != nil {
+			return failure, err
+		}
+
+		for _, test := range tests.Items {
+			if test.Result != "failure" && test.Result != "error" {
+				continue
+			}
+
+			failure.Tests = append(failure.Tests, FailedTest{
+				Job:     job.Name,
+				Suite:   test.Classname,
+				Name:    test.Name,
+				Message: test.Message,
+			})
+		}
+	}
+
+	return failure, nil
+}
+
+// Retry reruns the failed jobs of the workflow. The rerun is a new workflow with the same name,
+// which hides the failed one in the next poll.
+func (s *circleCiV2) Retry(org, repo string, build Build) (Build, error) {
+	if len(build.Id) == 0 {
+		return Build{}, errors.New("build has no workflow id")
+	}
+
+	var rerun struct {
+		WorkflowId string `json:"workflow_id"`
+	}
+
+	if err := s.request("POST", "/workflow/"+build.Id+"/rerun", map[string]bool{"from_failed": true}, &rerun); err != nil {
+		return Build{}, err
+	}
+
+	retried := build
+	retried.Id = rerun.WorkflowId
+	retried.Status = CiPending
+	retried.Url = fmt.Sprintf("https://app.circleci.com/workflow-run/%s", rerun.WorkflowId)
+
+	return retried, nil
+}
+
+func (p pipeline) of(shaOrTag string) bool {
+	return p.Vcs.Revision == shaOrTag || p.Vcs.Tag == shaOrTag
+}
+
+// get fetches the pipelines and workflows which change with every poll, shared in the window.
+func (s *circleCiV2) get(path string, v interface{}) error {
+	body, err := s.polls.fetch(path, func() (interface{}, error) {
+		var body json.RawMessage
+		err := s.request("GET", path, nil, &body)
+
+		return []byte(body), err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body.([]byte), v)
+}
+
+func (s *circleCiV2) request(method, path string, payload, v interface{}) error {
+	var reqBody io.Reader
+	if payload != nil {
+		bts, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		reqBody = bytes.NewReader(bts)
+	}
+
+	req, err := http.NewRequest(method, s.url+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Circle-Token", s.token)
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("circleci responded with %d: %s", resp.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func circleCiV2StandIn(t *testing.T, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+
+		if r.Header.Get("Circle-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery {
+		case "GET /project/gh/fubotv/api/pipeline?branch=master":
+			w.Write([]byte(`{"items": [{"id": "p3", "number": 3, "vcs": {"revision": "c", "branch": "master"}}], "next_page_token": "next"}`))
+
+		case "GET /project/gh/fubotv/api/pipeline?branch=master&page-token=next":
+			w.Write([]byte(`{"items": [
+			  {"id": "p2", "number": 2, "vcs": {"revision": "a", "branch": "master"}},
+			  {"id": "p1", "number": 1, "vcs": {"revision": "a", "branch": "master"}}
+			]}`))
+
+		case "GET /project/gh/fubotv/api/pipeline?":
+			w.Write([]byte(`{"items": [{"id": "p4", "number": 4, "vcs": {"tag": "release-1.0.0"}}]}`))
+
+		case "GET /pipeline/p2/workflow?":
+			w.Write([]byte(`{"items": [
+			  {"id": "w3", "name": "build", "status": "success"},
+			  {"id": "w2", "name": "deploy", "status": "on_hold"},
+			  {"id": "w1", "name": "build", "status": "failed"}
+			]}`))
+
+		case "GET /pipeline/p1/workflow?":
+			w.Write([]byte(`{"items": [
+			  {"id": "w0", "name": "lint", "status": "success"},
+			  {"id": "w9", "name": "build", "status": "failed"}
+			]}`))
+
+		case "GET /pipeline/p4/workflow?":
+			w.Write([]byte(`{"items": [{"id": "w4", "name": "release", "status": "running"}]}`))
+
+		case "GET /workflow/w1/job?":
+			w.Write([]byte(`{"items": [
+			  {"name": "lint", "job_number": 11, "status": "success"},
+			  {"name": "test", "job_number": 12, "status": "failed"}
+			]}`))
+
+		case "GET /project/gh/fubotv/api/12/tests?":
+			w.Write([]byte(`{"items": [
+			  {"name": "adds", "classname": "calc", "result": "failure", "message": "1 != 2"},
+			  {"name": "subtracts", "classname": "calc", "result": "success"}
+			]}`))
+
+		case "POST /workflow/w1/rerun?":
+			if body, _ := ioutil.ReadAll(r.Body); string(body) != `{"from_failed":true}` {
+				t.Errorf("unexpected rerun body %s", body)
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"workflow_id": "w5"}`))
+
+		default:
+			t.Errorf("unexpected request %s %s?%s", r.Method, r.URL.Path, r.URL.RawQuery)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCircleCiV2TracksWorkflows(t *testing.T) {
+	var calls int32
+	server := circleCiV2StandIn(t, &calls)
+	defer server.Close()
+
+	provider := NewCircleCiV2("token", 0).(*circleCiV2)
+	provider.url = server.URL
+
+	builds, err := provider.Builds("fubotv", "api", "master", "a", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Build{
+		{Provider: ProviderCircleCi, Name: "build", Status: CiSuccess, Sha: "a", Branch: "master", Url: "https://app.circleci.com/pipelines/gh/fubotv/api/2/workflows/w3", Id: "w3"},
+		{Provider: ProviderCircleCi, Name: "deploy", Status: CiOnHold, Sha: "a", Branch: "master", Url: "https://app.circleci.com/pipelines/gh/fubotv/api/2/workflows/w2", Id: "w2"},
+		// the workflows of the older pipeline of the commit count too, unless a newer one has the name
+		{Provider: ProviderCircleCi, Name: "lint", Status: CiSuccess, Sha: "a", Branch: "master", Url: "https://app.circleci.com/pipelines/gh/fubotv/api/1/workflows/w0", Id: "w0"},
+	}
+
+	if len(builds) != len(expected) {
+		t.Fatalf("expected %d builds, got %+v", len(expected), builds)
+	}
+
+	for i := range expected {
+		if builds[i] != expected[i] {
+			t.Errorf("build %d: expected %+v, got %+v", i, expected[i], builds[i])
+		}
+	}
+
+	tagged, err := provider.Builds("fubotv", "api", "", "release-1.0.0", time.Time{})
+	if err != nil || len(tagged) != 1 || tagged[0].Status != CiRunning || tagged[0].Tag != "release-1.0.0" {
+		t.Errorf("unexpected tag builds: %+v %v", tagged, err)
+	}
+
+	provider.token = "wrong"
+	if _, err := provider.Builds("fubotv", "api", "master", "a", time.Time{}); err == nil {
+		t.Error("expected an error for a rejected token")
+	}
+}
+
+func TestCircleCiV2SharesPolls(t *testing.T) {
+	var calls int32
+	server := circleCiV2StandIn(t, &calls)
+	defer server.Close()
+
+	provider := NewCircleCiV2("token", time.Minute).(*circleCiV2)
+	provider.url = server.URL
+
+	for i := 0; i < 3; i++ {
+		if builds, err := provider.Builds("fubotv", "api", "master", "a", time.Time{}); err != nil || len(builds) != 3 {
+			t.Fatalf("unexpected builds: %+v %v", builds, err)
+		}
+	}
+
+	// two pipeline pages and two workflow lists
+	if calls := atomic.LoadInt32(&calls); calls != 4 {
+		t.Errorf("expected 4 requests, got %d", calls)
+	}
+}
+
+func TestCircleCiV2DescribesAndRetries(t *testing.T) {
+	var calls int32
+	server := circleCiV2StandIn(t, &calls)
+	defer server.Close()
+
+	provider := NewCircleCiV2("token", 0).(*circleCiV2)
+	provider.url = server.URL
+
+	failed := Build{Provider: ProviderCircleCi, Name: "build", Status: CiFailed, Id: "w1"}
+
+	failure, err := provider.Failure("fubotv", "api", failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := FailedTest{Job: "test", Suite: "calc", Name: "adds", Message: "1 != 2"}
+	if len(failure.Tests) != 1 || failure.Tests[0] != expected {
+		t.Errorf("unexpected failed tests: %+v", failure.Tests)
+	}
+
+	rerun, err := provider.Retry("fubotv", "api", failed)
+	if err != nil || rerun.Id != "w5" || rerun.Name != "build" || rerun.Status != CiPending {
+		t.Errorf("unexpected rerun: %+v %v", rerun, err)
+	}
+
+	if _, err := provider.Retry("fubotv", "api", Build{Name: "build"}); err == nil {
+		t.Error("expected an error for a build without a workflow id")
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrUnsupportedCiEvent = errors.New("unsupported event")
+
+const circleCiSignaturePrefix = "v1="
+
+type circleCiWebhook struct {
+	secrets [][]byte
+}
+
+type circleCiPayload struct {
+	Id      string `json:"id"`
+	Type    string `json:"type"`
+	Project struct {
+		Slug string `json:"slug"`
+	} `json:"project"`
+	Pipeline struct {
+		Vcs struct {
+			Revision string `json:"revision"`
+			Branch   string `json:"branch"`
+			Tag      string `json:"tag"`
+		} `json:"vcs"`
+	} `json:"pipeline"`
+	Workflow struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Url    string `json:"url"`
+	} `json:"workflow"`
+	Job struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	} `json:"job"`
+}
+
+func NewCircleCiWebhook(webhookSecrets []string) CircleCiHooks {
+	return &circleCiWebhook{
+		secrets: trimSecrets(webhookSecrets),
+	}
+}
+
+// VerifySignature checks the circleci-signature header. It may carry several
+// comma separated signatures of different versions, only v1 is known.
+func (s *circleCiWebhook) VerifySignature(signature string, body []byte) error {
+	if len(s.secrets) == 0 {
+		return ErrNoWebhookSecrets
+	}
+
+	if len(signature) == 0 {
+		return ErrMissingSignature
+	}
+
+	found := false
+	for _, part := range strings.Split(signature, ",") {
+		part = strings.TrimSpace(part)
+		if !strings.HasPrefix(part, circleCiSignaturePrefix) {
+			continue
+		}
+
+		expected, err := hex.DecodeString(strings.TrimPrefix(part, circleCiSignaturePrefix))
+		if err != nil {
+			continue
+		}
+
+		found = true
+		if signedByAny(s.secrets, expected, body) {
+			return nil
+		}
+	}
+
+	if !found {
+		return ErrMalformedSignature
+	}
+
+	return ErrSignatureMismatch
+}
+
+func (s *circleCiWebhook) ParseWebhook(body []byte) (*CiResult, error) {
+	var payload circleCiPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	// slug looks like gh/org/repo
+	slug := strings.Split(payload.Project.Slug, "/")
+	if len(slug) != 3 {
+		return nil, errors.New("malformed project slug " + payload.Project.Slug)
+	}
+
+	result := &CiResult{
+		Id:       payload.Id,
+		Provider: ProviderCircleCi,
+		Org:      slug[1],
+		Repo:     slug[2],
+		Branch:   payload.Pipeline.Vcs.Branch,
+		Sha:      payload.Pipeline.Vcs.Revision,
+		Tag:      payload.Pipeline.Vcs.Tag,
+		Url:      payload.Workflow.Url,
+	}
+
+	switch payload.Type {
+	case "workflow-completed":
+		result.Kind = CiResultWorkflow
+		result.Name = payload.Workflow.Name
+		result.Status = circleCiStatus(payload.Workflow.Status)
+
+	case "job-completed":
+		result.Kind = CiResultJob
+		result.Name = payload.Job.Name
+		result.Status = circleCiStatus(payload.Job.Status)
+
+	default:
+		return nil, ErrUnsupportedCiEvent
+	}
+
+	return result, nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+const workflowCompleted = `{
+  "id": "3888f21b-eaa7-38e3-8f3d-75a63bba8895",
+  "type": "workflow-completed",
+  "project": {"slug": "gh/fubotv/api"},
+  "pipeline": {"vcs": {"revision": "1285fe1d", "branch": "master"}},
+  "workflow": {"name": "build", "status": "failed", "url": "https://app.circleci.com/pipelines/gh/fubotv/api/1/workflows/1"}
+}`
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestCircleCiWebhookVerifiesSignature(t *testing.T) {
+	hooks := NewCircleCiWebhook([]string{"old", "new"})
+
+	tests := []struct {
+		signature string
+		expected  error
+	}{
+		{"v1=" + sign("new", workflowCompleted), nil},
+		{"v2=abc,v1=" + sign("old", workflowCompleted), nil},
+		{"v1=" + sign("other", workflowCompleted), ErrSignatureMismatch},
+		{"v2=abc", ErrMalformedSignature},
+		{"", ErrMissingSignature},
+	}
+
+	for _, test := range tests {
+		if err := hooks.VerifySignature(test.signature, []byte(workflowCompleted)); err != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.signature, test.expected, err)
+		}
+	}
+}
+
+func TestCircleCiWebhookParsesWorkflow(t *testing.T) {
+	result, err := NewCircleCiWebhook(nil).ParseWebhook([]byte(workflowCompleted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := CiResult{
+		Id:       "3888f21b-eaa7-38e3-8f3d-75a63bba8895",
+		Provider: ProviderCircleCi,
+		Kind:     CiResultWorkflow,
+		Org:      "fubotv",
+		Repo:     "api",
+		Branch:   "master",
+		Sha:      "1285fe1d",
+		Name:     "build",
+		Status:   CiFailed,
+		Url:      "https://app.circleci.com/pipelines/gh/fubotv/api/1/workflows/1",
+	}
+
+	if *result != expected {
+		t.Errorf("expected %+v, got %+v", expected, *result)
+	}
+
+	if _, err := NewCircleCiWebhook(nil).ParseWebhook([]byte(`{"type": "ping", "project": {"slug": "gh/a/b"}}`)); err != ErrUnsupportedCiEvent {
+		t.Errorf("expected %v, got %v", ErrUnsupportedCiEvent, err)
+	}
+}
@@ -5,6 +5,74 @@ const (
 	ReleaseEvent     = "release"
 	CreateEvent      = "create"
 
-	sourceGithub   = "github"
-	sourceCircleCi = "circleci"
+	WorkflowRunEvent = "workflow_run"
+	CheckSuiteEvent  = "check_suite"
+
+	PullRequestMergedEvent = "pull_request_merged"
+
+	BuildStatusSuccess      = "success"
+	BuildStatusBuildFailed  = "build_failed"
+	BuildStatusFetchFailed  = "fetch_failed"
+	BuildStatusSearchFailed = "search_failed"
+	BuildStatusWaitFailed   = "wait_failed"
+	BuildStatusCanceled     = "canceled"
+	BuildStatusSuperseded   = "superseded"
+	// BuildStatusOnHold is reported once when the build waits for an approval, the monitor keeps watching.
+	BuildStatusOnHold = "on_hold"
+	// BuildStatusStarted and BuildStatusProgress are reported while the build runs, when enabled for the repo.
+	BuildStatusStarted  = "started"
+	BuildStatusProgress = "progress"
+	// BuildStatusRetried is reported when the failed builds get retried, the monitor keeps watching them.
+	BuildStatusRetried = "retried"
+
+	PhaseSearching       = "searching"
+	PhaseWaitingGreen    = "waiting_green"
+	PhaseConfirmingGreen = "confirming_green"
+
+	CiResultWorkflow = "workflow"
+	CiResultJob      = "job"
+
+	sourceGithub = "github"
+
+	ProviderCircleCi      = "circleci"
+	ProviderGithubActions = "github_actions"
+	ProviderJenkins       = "jenkins"
+
+	// the build states every ci provider maps its own ones to
+	CiPending  = "pending"
+	CiRunning  = "running"
+	CiSuccess  = "success"
+	CiFailed   = "failed"
+	CiCanceled = "canceled"
+	CiOnHold   = "on_hold"
+
+	// the settings an override can turn off, named as in the config file
+	SettingJitterPercent         = "jitter_percent"
+	SettingWebhookFallback       = "webhook_fallback_s"
+	SettingProgressNotifications = "progress_notifications"
+	SettingFailedTests           = "failed_tests_max"
+	SettingLogTailLines          = "log_tail_lines"
+	SettingLogTailBytes          = "log_tail_bytes"
+	SettingRetries               = "retries"
 )
+
+// CiProviders lists the keys of the known ci providers.
+var CiProviders = []string{ProviderCircleCi, ProviderGithubActions, ProviderJenkins}
+
+// NotifiedEvents lists the events CiMonitor hands over to the notifier.
+var NotifiedEvents = []string{PullRequestMergedEvent, ReleaseEvent, CreateEvent}
+
+// BuildStatuses lists the outcomes CiMonitor reports for ci events.
+var BuildStatuses = []string{
+	BuildStatusSuccess,
+	BuildStatusBuildFailed,
+	BuildStatusFetchFailed,
+	BuildStatusSearchFailed,
+	BuildStatusWaitFailed,
+	BuildStatusCanceled,
+	BuildStatusSuperseded,
+	BuildStatusOnHold,
+	BuildStatusStarted,
+	BuildStatusProgress,
+	BuildStatusRetried,
+}
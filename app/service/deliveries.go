@@ -0,0 +1,39 @@
+package service
+
+import (
+	"expvar"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/kvstore"
+	"time"
+)
+
+var duplicateDeliveries = expvar.NewInt("github_duplicate_deliveries")
+
+type deliveries struct {
+	store *kvstore.Store
+	ttl   time.Duration
+}
+
+func NewDeliveries(store *kvstore.Store, ttl time.Duration) Deliveries {
+	return &deliveries{
+		store: store,
+		ttl:   ttl,
+	}
+}
+
+// Seen records the delivery id and reports whether it has been recorded before.
+func (s *deliveries) Seen(id string) (bool, error) {
+	if len(id) == 0 {
+		return false, nil
+	}
+
+	stored, err := s.store.PutIfAbsent(id, time.Now(), s.ttl)
+	if err != nil {
+		return false, err
+	}
+
+	if !stored {
+		duplicateDeliveries.Add(1)
+	}
+
+	return !stored, nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"github.com/kudrykv/services-deploy-monitor/app/internal/kvstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeliveriesSeen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deliveries")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store, err := kvstore.Open(filepath.Join(dir, "deliveries.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := NewDeliveries(store, 20*time.Millisecond)
+	duplicates := duplicateDeliveries.Value()
+
+	steps := []struct {
+		id       string
+		sleep    time.Duration
+		expected bool
+	}{
+		{id: "a", expected: false},
+		{id: "a", expected: true},
+		{id: "b", expected: false},
+		{id: "", expected: false},
+		{id: "", expected: false},
+		{id: "a", sleep: 30 * time.Millisecond, expected: false},
+	}
+
+	for idx, step := range steps {
+		time.Sleep(step.sleep)
+
+		seen, err := ds.Seen(step.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if seen != step.expected {
+			t.Errorf("step %d: expected seen %v for %q, got %v", idx, step.expected, step.id, seen)
+		}
+	}
+
+	if got := duplicateDeliveries.Value() - duplicates; got != 1 {
+		t.Errorf("expected one duplicate counted, got %d", got)
+	}
+}
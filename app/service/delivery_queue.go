@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/kudrykv/services-deploy-monitor/app/config"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/httputil"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/kvstore"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/logging"
+	"github.com/rs/xid"
+	"time"
+)
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrQueueFull          = errors.New("delivery queue is full")
+)
+
+type deliveryQueue struct {
+	cfg     config.Delivery
+	routing Routing
+	dead    *kvstore.Store
+	queue   chan Outgoing
+}
+
+func NewDeliveryQueue(cfg config.Delivery, routing Routing, dead *kvstore.Store) DeliveryQueue {
+	return &deliveryQueue{
+		cfg:     cfg,
+		routing: routing,
+		dead:    dead,
+		queue:   make(chan Outgoing, cfg.QueueSize),
+	}
+}
+
+func (s *deliveryQueue) Start(ctx context.Context) {
+	for i := 0; i < s.cfg.Workers; i++ {
+		go s.work(ctx)
+	}
+}
+
+func (s *deliveryQueue) Enqueue(ctx context.Context, msg Outgoing) {
+	if len(msg.Id) == 0 {
+		msg.Id = xid.New().String()
+	}
+
+	if len(msg.RequestId) == 0 {
+		msg.RequestId = httputil.GetRequestId(ctx)
+	}
+
+	// the webhook handlers enqueue, they must not hang on a slack outage
+	select {
+	case s.queue <- msg:
+	default:
+		s.bury(logrus.Fields{
+			"request_id": msg.RequestId,
+			"message_id": msg.Id,
+			"slack":      msg.Slack,
+			"room":       msg.Room,
+		}, msg, ErrQueueFull)
+	}
+}
+
+func (s *deliveryQueue) DeadLetters() ([]Outgoing, error) {
+	letters := []Outgoing{}
+
+	err := s.dead.Each(func(key string, value json.RawMessage) error {
+		var msg Outgoing
+		if err := json.Unmarshal(value, &msg); err != nil {
+			return err
+		}
+
+		letters = append(letters, msg)
+		return nil
+	})
+
+	return letters, err
+}
+
+func (s *deliveryQueue) Resend(ctx context.Context, id string) error {
+	var msg Outgoing
+	found, err := s.dead.Get(id, &msg)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrDeadLetterNotFound
+	}
+
+	if err := s.dead.Delete(id); err != nil {
+		return err
+	}
+
+	msg.Attempts = 0
+	msg.LastError = ""
+	msg.FailedAt = nil
+	s.Enqueue(ctx, msg)
+
+	return nil
+}
+
+func (s *deliveryQueue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case msg := <-s.queue:
+			s.attempt(ctx, msg)
+		}
+	}
+}
+
+func (s *deliveryQueue) attempt(ctx context.Context, msg Outgoing) {
+	fields := logrus.Fields{
+		"request_id": msg.RequestId,
+		"message_id": msg.Id,
+		"slack":      msg.Slack,
+		"room":       msg.Room,
+	}
+
+	msg.Attempts += 1
+	err := s.deliver(httputil.AddCustomRequestId(ctx, msg.RequestId), msg)
+	if err == nil {
+		logging.WithFields(fields).WithFields(logrus.Fields{"attempts": msg.Attempts}).Info("message delivered")
+		return
+	}
+
+	fields["attempts"] = msg.Attempts
+
+	if msg.Attempts >= s.cfg.MaxAttempts {
+		s.bury(fields, msg, err)
+		return
+	}
+
+	msg.LastError = err.Error()
+	fields["err"] = err
+
+	delay := s.backoff(msg.Attempts)
+	logging.WithFields(fields).WithFields(logrus.Fields{"retry_in": delay.String()}).Warn("deliver message, retry")
+
+	time.AfterFunc(delay, func() {
+		select {
+		case <-ctx.Done():
+		case s.queue <- msg:
+		}
+	})
+}
+
+// bury moves the message to the dead letters, from where it can be resent by hand.
+func (s *deliveryQueue) bury(fields logrus.Fields, msg Outgoing, reason error) {
+	now := time.Now()
+	msg.FailedAt = &now
+	msg.LastError = reason.Error()
+
+	if err := s.dead.Put(msg.Id, msg, 0); err != nil {
+		logging.WithFields(fields).WithFields(logrus.Fields{"err": reason, "store_err": err}).Error("lost message, store dead letter")
+		return
+	}
+
+	logging.WithFields(fields).WithFields(logrus.Fields{"err": reason}).Error("message moved to dead letters")
+}
+
+func (s *deliveryQueue) deliver(ctx context.Context, msg Outgoing) error {
+	slack := msg.slack
+	if slack == nil {
+		var ok bool
+		if slack, ok = s.routing.Config().Slacks[msg.Slack]; !ok {
+			return errors.New("slack " + msg.Slack + " has not been found")
+		}
+	}
+
+	if err := slack.SendMessage(ctx, msg.Room, msg.Text); err != nil {
+		return fmt.Errorf("send to room %s: %v", msg.Room, err)
+	}
+
+	return nil
+}
+
+// backoff doubles the delay after every failed attempt, starting from the base and capped by the max.
+func (s *deliveryQueue) backoff(attempts int) time.Duration {
+	delay := time.Duration(s.cfg.BackoffBaseMs) * time.Millisecond
+	max := time.Duration(s.cfg.BackoffMaxMs) * time.Millisecond
+
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	return delay
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"github.com/kudrykv/services-deploy-monitor/app/config"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/kvstore"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, url string) (*deliveryQueue, func()) {
+	dir, err := ioutil.TempDir("", "dead-letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := kvstore.Open(filepath.Join(dir, "dead-letters.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Delivery{Workers: 1, QueueSize: 10, MaxAttempts: 3, BackoffBaseMs: 1, BackoffMaxMs: 5}
+	queue := NewDeliveryQueue(cfg, NewRouting(Config{Slacks: map[string]Slack{"team": NewSlack(url)}}), store).(*deliveryQueue)
+
+	return queue, func() { os.RemoveAll(dir) }
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDeliveryQueueDeadLettersAfterRetries(t *testing.T) {
+	si, ts := newSlackStandIn(t, http.StatusInternalServerError, "boom")
+	defer ts.Close()
+
+	queue, cleanup := newTestQueue(t, ts.URL)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+
+	queue.Enqueue(ctx, Outgoing{Slack: "team", Room: "deploys", Text: "hello"})
+
+	var letters []Outgoing
+	waitFor(t, func() bool {
+		letters, _ = queue.DeadLetters()
+		return len(letters) == 1
+	})
+
+	if letters[0].Attempts != 3 || !strings.Contains(letters[0].LastError, "boom") {
+		t.Errorf("unexpected dead letter: %+v", letters[0])
+	}
+
+	id := letters[0].Id
+	si.respondWith(http.StatusOK)
+	if err := queue.Resend(ctx, id); err != nil {
+		t.Fatalf("resend: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		return len(si.received()) == 4
+	})
+
+	if err := queue.Resend(ctx, id); err != ErrDeadLetterNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestDeliveryQueueBackoff(t *testing.T) {
+	queue := &deliveryQueue{cfg: config.Delivery{BackoffBaseMs: 100, BackoffMaxMs: 1000}}
+
+	expected := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, e := range expected {
+		if got := queue.backoff(i + 1); got != e*time.Millisecond {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, e*time.Millisecond, got)
+		}
+	}
+}
+
+func TestSendReportsContext(t *testing.T) {
+	_, ts := newSlackStandIn(t, http.StatusInternalServerError, "boom")
+	defer ts.Close()
+
+	queue, cleanup := newTestQueue(t, ts.URL)
+	defer cleanup()
+
+	err := queue.deliver(context.Background(), Outgoing{Slack: "team", Room: "deploys", Text: "api"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if !strings.Contains(err.Error(), "deploys") || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("error lacks context: %v", err)
+	}
+}
+
+func TestDeliveryQueueDoesNotBlockWhenFull(t *testing.T) {
+	queue, cleanup := newTestQueue(t, "http://localhost")
+	defer cleanup()
+
+	// not started, nothing drains the queue
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 11; i++ {
+			queue.Enqueue(context.Background(), Outgoing{Slack: "team", Room: "deploys", Text: "hello"})
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("enqueue blocked on a full queue")
+	}
+
+	letters, err := queue.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(letters) != 1 || letters[0].LastError != ErrQueueFull.Error() || letters[0].Attempts != 0 {
+		t.Errorf("expected the overflow in dead letters, got %+v", letters)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+type githubActions struct {
+	gh GhWrap
+}
+
+// NewGithubActions looks up the workflow runs of the commit, one build per workflow.
+func NewGithubActions(gh GhWrap) CiProvider {
+	return &githubActions{
+		gh: gh,
+	}
+}
+
+func (s *githubActions) Name() string {
+	return ProviderGithubActions
+}
+
+func (s *githubActions) Builds(org, repo, branch, shaOrTag string, since time.Time) ([]Build, error) {
+	runs, err := s.gh.WorkflowRuns(context.Background(), org, repo, shaOrTag)
+	if err != nil {
+		return nil, err
+	}
+
+	var builds []Build
+	for _, run := range runs {
+		// for tag pushes head_branch holds the tag
+		if run.HeadSha != shaOrTag && run.HeadBranch != shaOrTag {
+			continue
+		}
+
+		build := Build{
+			Provider:  ProviderGithubActions,
+			Name:      run.Name,
+			Status:    actionsStatus(run.Status, run.Conclusion),
+			Url:       run.HtmlUrl,
+			Sha:       run.HeadSha,
+			Branch:    run.HeadBranch,
+			StartedAt: run.RunStartedAt,
+		}
+
+		if run.Status == "completed" {
+			build.StoppedAt = run.UpdatedAt
+		}
+
+		builds = append(builds, build)
+	}
+
+	return builds, nil
+}
+
+// actionsResult turns workflow_run and check_suite webhooks into a ci result.
+// Check suites of other github apps are not github actions and get skipped.
+func actionsResult(hook AggregatedWebhook) (CiResult, bool) {
+	switch {
+	case hook.Event == WorkflowRunEvent && hook.WorkflowRunEvent != nil:
+		run := hook.WorkflowRunEvent.WorkflowRun
+
+		return CiResult{
+			Id:       strconv.FormatInt(run.Id, 10),
+			Provider: ProviderGithubActions,
+			Kind:     CiResultWorkflow,
+			Org:      hook.WorkflowRunEvent.Repo.GetOwner().GetLogin(),
+			Repo:     hook.WorkflowRunEvent.Repo.GetName(),
+			Branch:   run.HeadBranch,
+			Sha:      run.HeadSha,
+			Tag:      run.HeadBranch,
+			Name:     run.Name,
+			Status:   actionsStatus(run.Status, run.Conclusion),
+			Url:      run.HtmlUrl,
+		}, true
+
+	case hook.Event == CheckSuiteEvent && hook.CheckSuiteEvent != nil:
+		suite := hook.CheckSuiteEvent.CheckSuite
+		if suite.App.Slug != "github-actions" {
+			return CiResult{}, false
+		}
+
+		return CiResult{
+			Id:       strconv.FormatInt(suite.Id, 10),
+			Provider: ProviderGithubActions,
+			Kind:     CiResultWorkflow,
+			Org:      hook.CheckSuiteEvent.Repo.GetOwner().GetLogin(),
+			Repo:     hook.CheckSuiteEvent.Repo.GetName(),
+			Branch:   suite.HeadBranch,
+			Sha:      suite.HeadSha,
+			Tag:      suite.HeadBranch,
+			Name:     "check suite",
+			Status:   actionsStatus(suite.Status, suite.Conclusion),
+		}, true
+
+	default:
+		return CiResult{}, false
+	}
+}
+
+func actionsStatus(status, conclusion string) string {
+	switch status {
+	case "completed":
+	case "in_progress":
+		return CiRunning
+	default:
+		return CiPending
+	}
+
+	switch conclusion {
+	case "success", "neutral", "skipped":
+		return CiSuccess
+
+	case "cancelled", "stale":
+		return CiCanceled
+
+	default:
+		return CiFailed
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const workflowRunCompleted = `{
+  "action": "completed",
+  "workflow_run": {
+    "id": 30433642,
+    "name": "Build",
+    "head_branch": "master",
+    "head_sha": "acb5820ced9479c074f688cc328bf03f341a511d",
+    "status": "completed",
+    "conclusion": "failure",
+    "html_url": "https://github.com/fubotv/web/actions/runs/30433642"
+  },
+  "repository": {"name": "web", "owner": {"login": "fubotv"}}
+}`
+
+func TestActionsResultFromWorkflowRun(t *testing.T) {
+	gh := NewGithub("", "fubotv", nil)
+	if !gh.IsEventSupported(WorkflowRunEvent) || !gh.IsEventSupported(CheckSuiteEvent) {
+		t.Fatal("github actions events are not supported")
+	}
+
+	hook, err := gh.ParseWebhook(context.Background(), WorkflowRunEvent, []byte(workflowRunCompleted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, ok := actionsResult(*hook)
+	expected := CiResult{
+		Id:       "30433642",
+		Provider: ProviderGithubActions,
+		Kind:     CiResultWorkflow,
+		Org:      "fubotv",
+		Repo:     "web",
+		Branch:   "master",
+		Sha:      "acb5820ced9479c074f688cc328bf03f341a511d",
+		Tag:      "master",
+		Name:     "Build",
+		Status:   CiFailed,
+		Url:      "https://github.com/fubotv/web/actions/runs/30433642",
+	}
+
+	if !ok || result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestActionsResultSkipsOtherApps(t *testing.T) {
+	body := `{"action": "completed", "check_suite": {"head_sha": "a", "status": "completed", "conclusion": "success", "app": {"slug": "circleci-checks"}}, "repository": {"name": "web"}}`
+
+	hook, err := NewGithub("", "fubotv", nil).ParseWebhook(context.Background(), CheckSuiteEvent, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := actionsResult(*hook); ok {
+		t.Error("check suite of another app has been taken")
+	}
+}
+
+type runsGhWrap struct {
+	GhWrap
+	runs []WorkflowRun
+}
+
+func (g runsGhWrap) WorkflowRuns(ctx context.Context, org, repo, ref string) ([]WorkflowRun, error) {
+	return g.runs, nil
+}
+
+func TestGithubActionsMapsRuns(t *testing.T) {
+	provider := NewGithubActions(runsGhWrap{runs: []WorkflowRun{
+		{Name: "build", HeadSha: "a", Status: "completed", Conclusion: "success"},
+		{Name: "lint", HeadSha: "a", Status: "completed", Conclusion: "skipped"},
+		{Name: "deploy", HeadSha: "a", Status: "completed", Conclusion: "cancelled"},
+		{Name: "e2e", HeadSha: "a", Status: "completed", Conclusion: "timed_out"},
+		{Name: "docs", HeadSha: "a", Status: "in_progress"},
+		{Name: "bench", HeadSha: "a", Status: "queued"},
+		{Name: "other", HeadSha: "b", Status: "completed", Conclusion: "failure"},
+	}})
+
+	builds, err := provider.Builds("fubotv", "web", "master", "a", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{CiSuccess, CiSuccess, CiCanceled, CiFailed, CiRunning, CiPending}
+	if len(builds) != len(expected) {
+		t.Fatalf("expected %d builds, got %d", len(expected), len(builds))
+	}
+
+	for i, status := range expected {
+		if builds[i].Status != status || builds[i].Provider != ProviderGithubActions {
+			t.Errorf("build %s: expected %s, got %+v", builds[i].Name, status, builds[i])
+		}
+	}
+}
@@ -2,10 +2,15 @@ package service
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/google/go-github/github"
 	"golang.org/x/oauth2"
+	"net/url"
 	"regexp"
 	"sort"
 	"strings"
@@ -13,14 +18,25 @@ import (
 
 var releaseRegexTag = regexp.MustCompile("^release-\\d+W\\d+-\\d+\\.\\d+$")
 var releaseRegexBranch = regexp.MustCompile("^release-\\d+W\\d+-\\d+$")
+var shaRegex = regexp.MustCompile("^[0-9a-f]{40}$")
+
+var (
+	ErrNoWebhookSecrets   = errors.New("no webhook secrets configured")
+	ErrMissingSignature   = errors.New("missing signature")
+	ErrMalformedSignature = errors.New("malformed signature")
+	ErrSignatureMismatch  = errors.New("signature does not match any secret")
+)
+
+const signaturePrefix = "sha256="
 
 type ghWrap struct {
-	org string
+	org     string
+	secrets [][]byte
 
 	client *github.Client
 }
 
-func NewGithub(accessToken, org string) GhWrap {
+func NewGithub(accessToken, org string, webhookSecrets []string) GhWrap {
 	ts := oauth2.StaticTokenSource(&oauth2.Token{
 		AccessToken: accessToken,
 	})
@@ -29,8 +45,9 @@ func NewGithub(accessToken, org string) GhWrap {
 	client := github.NewClient(tc)
 
 	return &ghWrap{
-		org:    org,
-		client: client,
+		org:     org,
+		secrets: trimSecrets(webhookSecrets),
+		client:  client,
 	}
 }
 
@@ -168,9 +185,33 @@ func (s *ghWrap) Commit(ctx context.Context, org, repo, sha string) (*github.Rep
 	return rc, err
 }
 
+// WorkflowRuns lists the github actions runs of the commit, or of the tag when ref is not a sha.
+func (s *ghWrap) WorkflowRuns(ctx context.Context, org, repo, ref string) ([]WorkflowRun, error) {
+	filter := "head_sha"
+	if !shaRegex.MatchString(ref) {
+		filter = "branch"
+	}
+
+	u := fmt.Sprintf("repos/%v/%v/actions/runs?per_page=100&%v=%v", org, repo, filter, url.QueryEscape(ref))
+	req, err := s.client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs struct {
+		WorkflowRuns []WorkflowRun `json:"workflow_runs"`
+	}
+
+	if _, err := s.client.Do(ctx, req, &runs); err != nil {
+		return nil, err
+	}
+
+	return runs.WorkflowRuns, nil
+}
+
 func (s *ghWrap) IsEventSupported(event string) bool {
 	switch event {
-	case PullRequestEvent, ReleaseEvent, CreateEvent:
+	case PullRequestEvent, ReleaseEvent, CreateEvent, WorkflowRunEvent, CheckSuiteEvent:
 		return true
 
 	default:
@@ -194,9 +235,67 @@ func (s *ghWrap) ParseWebhook(ctx context.Context, event string, body []byte) (*
 	case CreateEvent:
 		err = json.Unmarshal(body, &hook.CreateEvent)
 
+	case WorkflowRunEvent:
+		err = json.Unmarshal(body, &hook.WorkflowRunEvent)
+
+	case CheckSuiteEvent:
+		err = json.Unmarshal(body, &hook.CheckSuiteEvent)
+
 	default:
 		err = errors.New("unrecognized event: " + event)
 	}
 
 	return &hook, err
 }
+
+// VerifySignature checks X-Hub-Signature-256 against every configured secret,
+// so that the old and the new secret both pass during rotation.
+func (s *ghWrap) VerifySignature(signature string, body []byte) error {
+	if len(s.secrets) == 0 {
+		return ErrNoWebhookSecrets
+	}
+
+	if len(signature) == 0 {
+		return ErrMissingSignature
+	}
+
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrMalformedSignature
+	}
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return ErrMalformedSignature
+	}
+
+	if !signedByAny(s.secrets, expected, body) {
+		return ErrSignatureMismatch
+	}
+
+	return nil
+}
+
+func trimSecrets(list []string) [][]byte {
+	secrets := [][]byte{}
+	for _, secret := range list {
+		if secret = strings.TrimSpace(secret); len(secret) > 0 {
+			secrets = append(secrets, []byte(secret))
+		}
+	}
+
+	return secrets
+}
+
+// signedByAny reports whether the body has been signed with HMAC-SHA256 by one of the secrets.
+func signedByAny(secrets [][]byte, expected, body []byte) bool {
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+
+		if hmac.Equal(mac.Sum(nil), expected) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestGithubVerifiesSignature(t *testing.T) {
+	const body = `{"action":"closed"}`
+
+	tests := []struct {
+		name      string
+		secrets   []string
+		signature string
+		expected  error
+	}{
+		{name: "valid", secrets: []string{"new"}, signature: "sha256=" + sign("new", body)},
+		{name: "old secret during rotation", secrets: []string{" new ", "old"}, signature: "sha256=" + sign("old", body)},
+		{name: "wrong secret", secrets: []string{"new"}, signature: "sha256=" + sign("other", body), expected: ErrSignatureMismatch},
+		{name: "missing header", secrets: []string{"new"}, expected: ErrMissingSignature},
+		{name: "sha1 header", secrets: []string{"new"}, signature: "sha1=abc", expected: ErrMalformedSignature},
+		{name: "not hex", secrets: []string{"new"}, signature: "sha256=zz", expected: ErrMalformedSignature},
+		{name: "no secrets", secrets: []string{" "}, signature: "sha256=" + sign("new", body), expected: ErrNoWebhookSecrets},
+	}
+
+	for _, test := range tests {
+		gh := NewGithub("", "org", test.secrets)
+		if err := gh.VerifySignature(test.signature, []byte(body)); err != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/google/go-github/github"
 	"github.com/kudrykv/go-circleci"
+	"time"
 )
 
 type Changelog interface {
@@ -12,10 +13,54 @@ type Changelog interface {
 
 type CiMonitor interface {
 	Monitor(ctx context.Context, hook AggregatedWebhook, f func(context.Context, Event))
+	Resume(ctx context.Context, f func(context.Context, Event))
+	List() []MonitorState
+	Get(id string) (MonitorState, bool)
+	Cancel(id string, notify bool) error
+	SetOverrides(overrides []MonitorOverride)
+	Deliver(result CiResult) int
+}
+
+type CircleCiHooks interface {
+	VerifySignature(signature string, body []byte) error
+	ParseWebhook(body []byte) (*CiResult, error)
 }
 
 type CircleCi interface {
-	BuildsForProjectMatching(org, repo, branch, sha string) ([]circleci.Build, error)
+	RecentBuilds(org, repo, branch string, offset int) ([]circleci.Build, error)
+	Build(org, repo string, num int) (*circleci.Build, error)
+	Artifacts(org, repo string, num int) ([]circleci.Artifact, error)
+	Retry(org, repo string, num int) (*circleci.Build, error)
+	// Download fetches step outputs and artifacts, which need the token for private projects.
+	Download(url string) ([]byte, error)
+}
+
+// CiProvider looks up the builds of a commit in one ci. Builds started before since
+// cannot belong to the commit, zero since means the commit time is unknown.
+type CiProvider interface {
+	Name() string
+	Builds(org, repo, branch, shaOrTag string, since time.Time) ([]Build, error)
+}
+
+// CiFailures is implemented by the providers which can tell why a build failed.
+type CiFailures interface {
+	Failure(org, repo string, build Build) (Failure, error)
+}
+
+// CiRetrier is implemented by the providers which can rerun a failed build.
+type CiRetrier interface {
+	Retry(org, repo string, build Build) (Build, error)
+}
+
+type DeliveryQueue interface {
+	Start(ctx context.Context)
+	Enqueue(ctx context.Context, msg Outgoing)
+	DeadLetters() ([]Outgoing, error)
+	Resend(ctx context.Context, id string) error
+}
+
+type Deliveries interface {
+	Seen(id string) (bool, error)
 }
 
 type GhWrap interface {
@@ -25,6 +70,8 @@ type GhWrap interface {
 	Compare(ctx context.Context, repo, base, head string) (*github.CommitsComparison, error)
 	Commits(ctx context.Context, repo, base string, pages, perPage int) ([]*github.RepositoryCommit, error)
 	Commit(ctx context.Context, org, repo, sha string) (*github.RepositoryCommit, error)
+	WorkflowRuns(ctx context.Context, org, repo, ref string) ([]WorkflowRun, error)
+	VerifySignature(signature string, body []byte) error
 	IsEventSupported(event string) bool
 	ParseWebhook(ctx context.Context, event string, body []byte) (*AggregatedWebhook, error)
 }
@@ -33,5 +80,12 @@ type Notifier interface {
 	Do(context.Context, Event)
 }
 
+// Routing holds the routing config together with the slacks it points at.
+type Routing interface {
+	Config() Config
+	Set(cfg Config)
+}
+
 type Slack interface {
+	SendMessage(ctx context.Context, channel, text string) error
 }
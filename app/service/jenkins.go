@@ -0,0 +1,212 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const jenkinsBuildsTree = "builds[number,url,result,building,timestamp,duration,actions[lastBuiltRevision[SHA1]]]{0,50}"
+
+type jenkins struct {
+	url     string
+	user    string
+	token   string
+	jobPath string
+	client  *http.Client
+}
+
+type jenkinsJob struct {
+	Builds []jenkinsBuild `json:"builds"`
+}
+
+type jenkinsBuild struct {
+	Number    int    `json:"number"`
+	Url       string `json:"url"`
+	Result    string `json:"result"`
+	Building  bool   `json:"building"`
+	Timestamp int64  `json:"timestamp"`
+	Duration  int64  `json:"duration"`
+	Actions   []struct {
+		LastBuiltRevision *struct {
+			Sha1 string `json:"SHA1"`
+		} `json:"lastBuiltRevision"`
+	} `json:"actions"`
+}
+
+// NewJenkins looks up the builds of the job through the jenkins json api. The latest run of the commit
+// is its build, named after the repo so that required and optional jobs can list it.
+func NewJenkins(baseUrl, user, token, jobPath string) CiProvider {
+	return &jenkins{
+		url:     strings.TrimSuffix(baseUrl, "/"),
+		user:    user,
+		token:   token,
+		jobPath: jobPath,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: http.RoundTripper(&http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+					DualStack: true,
+				}).DialContext,
+				MaxIdleConns:          10,
+				IdleConnTimeout:       90 * time.Second,
+				TLSHandshakeTimeout:   5 * time.Second,
+				ExpectContinueTimeout: 1 * time.Second,
+			}),
+		},
+	}
+}
+
+func (s *jenkins) Name() string {
+	return ProviderJenkins
+}
+
+func (s *jenkins) Builds(org, repo, branch, shaOrTag string, since time.Time) ([]Build, error) {
+	// releases come without a branch, their job is named by the tag
+	byTag := len(branch) == 0
+	if byTag {
+		branch = shaOrTag
+	}
+
+	job, err := s.job(s.path(org, repo, branch))
+	if err != nil {
+		return nil, err
+	}
+
+	// jenkins lists the runs newest first, a rerun hides the earlier runs of the commit
+	for _, jb := range job.Builds {
+		if jb.Timestamp > 0 && !since.IsZero() && jb.started().Before(since) {
+			break
+		}
+
+		sha := jb.sha()
+		if !byTag && sha != shaOrTag {
+			continue
+		}
+
+		build := Build{
+			Provider: ProviderJenkins,
+			Name:     repo,
+			Number:   jb.Number,
+			Status:   jenkinsStatus(jb.Building, jb.Result),
+			Url:      jb.Url,
+			Sha:      sha,
+			Branch:   branch,
+		}
+
+		if jb.Timestamp > 0 {
+			started := jb.started()
+			build.StartedAt = &started
+
+			if !jb.Building {
+				stopped := started.Add(time.Duration(jb.Duration) * time.Millisecond)
+				build.StoppedAt = &stopped
+			}
+		}
+
+		if byTag {
+			build.Tag = shaOrTag
+		}
+
+		return []Build{build}, nil
+	}
+
+	return nil, nil
+}
+
+// path turns the job path pattern into the url path, every segment is a /job/ of a folder.
+// Multibranch pipelines name the branch jobs by the escaped branch, so the branch is escaped twice.
+func (s *jenkins) path(org, repo, branch string) string {
+	replacer := strings.NewReplacer(
+		"{org}", url.PathEscape(org),
+		"{repo}", url.PathEscape(repo),
+		"{branch}", url.PathEscape(url.PathEscape(branch)),
+	)
+
+	path := ""
+	for _, segment := range strings.Split(strings.Trim(s.jobPath, "/"), "/") {
+		path += "/job/" + replacer.Replace(segment)
+	}
+
+	return path
+}
+
+func (s *jenkins) job(path string) (jenkinsJob, error) {
+	var job jenkinsJob
+
+	req, err := http.NewRequest("GET", s.url+path+"/api/json?tree="+url.QueryEscape(jenkinsBuildsTree), nil)
+	if err != nil {
+		return job, err
+	}
+
+	if len(s.user) > 0 {
+		req.SetBasicAuth(s.user, s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return job, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return job, err
+	}
+
+	// multibranch pipelines create the job of a new branch only after the scan
+	if resp.StatusCode == http.StatusNotFound {
+		return job, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return job, fmt.Errorf("jenkins responded with %d: %s", resp.StatusCode, body)
+	}
+
+	err = json.Unmarshal(body, &job)
+
+	return job, err
+}
+
+func (b jenkinsBuild) started() time.Time {
+	return time.Unix(0, b.Timestamp*int64(time.Millisecond))
+}
+
+func (b jenkinsBuild) sha() string {
+	for _, action := range b.Actions {
+		if action.LastBuiltRevision != nil {
+			return action.LastBuiltRevision.Sha1
+		}
+	}
+
+	return ""
+}
+
+func jenkinsStatus(building bool, result string) string {
+	if building {
+		return CiRunning
+	}
+
+	switch result {
+	case "SUCCESS":
+		return CiSuccess
+
+	case "FAILURE", "UNSTABLE":
+		return CiFailed
+
+	case "ABORTED", "NOT_BUILT":
+		return CiCanceled
+
+	default:
+		return CiPending
+	}
+}
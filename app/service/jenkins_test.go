@@ -0,0 +1,143 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type jenkinsStandIn struct {
+	mu    sync.Mutex
+	paths []string
+	jobs  map[string]string
+}
+
+func (j *jenkinsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	j.mu.Lock()
+	j.paths = append(j.paths, r.URL.EscapedPath())
+	j.mu.Unlock()
+
+	if user, token, ok := r.BasicAuth(); !ok || user != "bot" || token != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Query().Get("tree") != jenkinsBuildsTree {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	body, ok := j.jobs[r.URL.EscapedPath()]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Write([]byte(body))
+}
+
+const jenkinsMasterJob = `{"builds": [
+  {"number": 8, "url": "http://jenkins/job/api/job/master/8/", "building": true, "timestamp": 1546300800000,
+   "actions": [{}, {"lastBuiltRevision": {"SHA1": "a"}}]},
+  {"number": 7, "url": "http://jenkins/job/api/job/master/7/", "result": "SUCCESS", "timestamp": 1546300000000, "duration": 60000,
+   "actions": [{"lastBuiltRevision": {"SHA1": "f"}}]},
+  {"number": 6, "result": "FAILURE", "timestamp": 1546290000000, "actions": [{"lastBuiltRevision": {"SHA1": "f"}}]},
+  {"number": 5, "result": "FAILURE", "timestamp": 1546280000000, "actions": [{"lastBuiltRevision": {"SHA1": "a"}}]},
+  {"number": 4, "result": "FAILURE", "actions": [{"lastBuiltRevision": {"SHA1": "b"}}]},
+  {"number": 3, "result": "UNSTABLE", "actions": [{"lastBuiltRevision": {"SHA1": "c"}}]},
+  {"number": 2, "result": "ABORTED", "actions": [{"lastBuiltRevision": {"SHA1": "d"}}]}
+]}`
+
+const jenkinsTagJob = `{"builds": [{"number": 1, "result": "SUCCESS", "actions": [{"lastBuiltRevision": {"SHA1": "e"}}]}]}`
+
+func TestJenkinsFindsBuildsBySha(t *testing.T) {
+	standIn := &jenkinsStandIn{jobs: map[string]string{
+		"/job/api/job/master/api/json":            jenkinsMasterJob,
+		"/job/api/job/feature%252Flogin/api/json": jenkinsTagJob,
+		"/job/api/job/release-1.2.3/api/json":     jenkinsTagJob,
+	}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	provider := NewJenkins(server.URL+"/", "bot", "secret", "{repo}/{branch}")
+
+	builds, err := provider.Builds("fubotv", "api", "master", "a", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the running rerun hides the failed run
+	if len(builds) != 1 || builds[0].Status != CiRunning || builds[0].Name != "api" || builds[0].Number != 8 || builds[0].StoppedAt != nil {
+		t.Fatalf("unexpected builds: %+v", builds)
+	}
+
+	rerun, err := provider.Builds("fubotv", "api", "master", "f", time.Time{})
+	if err != nil || len(rerun) != 1 || rerun[0].Status != CiSuccess || rerun[0].Name != "api" {
+		t.Fatalf("expected the green rerun, got %+v %v", rerun, err)
+	}
+
+	if rerun[0].StoppedAt == nil || rerun[0].StoppedAt.Sub(*rerun[0].StartedAt).Seconds() != 60 {
+		t.Errorf("unexpected build times: %+v", rerun[0])
+	}
+
+	// the failed run started before the commit, it is of an earlier push
+	recent, err := provider.Builds("fubotv", "api", "master", "a", time.Unix(1546290000, 0))
+	if err != nil || len(recent) != 1 || recent[0].Number != 8 {
+		t.Errorf("unexpected recent builds: %+v %v", recent, err)
+	}
+
+	old, err := provider.Builds("fubotv", "api", "master", "f", time.Unix(1546300500, 0))
+	if err != nil || len(old) != 0 {
+		t.Errorf("runs started before since should be skipped: %+v %v", old, err)
+	}
+
+	tests := []struct {
+		sha      string
+		expected string
+	}{
+		{"b", CiFailed},
+		{"c", CiFailed},
+		{"d", CiCanceled},
+	}
+
+	for _, test := range tests {
+		builds, err := provider.Builds("fubotv", "api", "master", test.sha, time.Time{})
+		if err != nil || len(builds) != 1 || builds[0].Status != test.expected {
+			t.Errorf("%s: expected one %s build, got %+v %v", test.sha, test.expected, builds, err)
+		}
+	}
+
+	tagged, err := provider.Builds("fubotv", "api", "", "release-1.2.3", time.Time{})
+	if err != nil || len(tagged) != 1 || tagged[0].Tag != "release-1.2.3" || tagged[0].Status != CiSuccess {
+		t.Errorf("unexpected tag builds: %+v %v", tagged, err)
+	}
+
+	slashed, err := provider.Builds("fubotv", "api", "feature/login", "e", time.Time{})
+	if err != nil || len(slashed) != 1 || slashed[0].Branch != "feature/login" {
+		t.Errorf("unexpected builds of a slashed branch: %+v %v", slashed, err)
+	}
+
+	unknown, err := provider.Builds("fubotv", "api", "new-branch", "a", time.Time{})
+	if err != nil || len(unknown) != 0 {
+		t.Errorf("a job not scanned yet should have no builds: %+v %v", unknown, err)
+	}
+}
+
+func TestJenkinsReportsErrors(t *testing.T) {
+	server := httptest.NewServer(&jenkinsStandIn{})
+	defer server.Close()
+
+	if _, err := NewJenkins(server.URL, "bot", "wrong", "{repo}/{branch}").Builds("fubotv", "api", "master", "a", time.Time{}); err == nil {
+		t.Error("expected an error for rejected credentials")
+	}
+}
+
+func TestJenkinsJobPath(t *testing.T) {
+	provider := NewJenkins("http://jenkins", "", "", "/{org}/{repo}/{branch}/").(*jenkins)
+
+	if path := provider.path("fubotv", "api", "feature/login"); path != "/job/fubotv/job/api/job/feature%252Flogin" {
+		t.Errorf("unexpected path %s", path)
+	}
+}
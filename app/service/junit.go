@@ -0,0 +1,77 @@
+package service
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Class   string        `xml:"classname,attr"`
+	Name    string        `xml:"name,attr"`
+	Failure *junitFailure `xml:"failure"`
+	Error   *junitFailure `xml:"error"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// parseJUnit lists the failed and errored test cases of a junit report.
+// The root is either <testsuites> or a single <testsuite>, suites may nest.
+func parseJUnit(job string, body []byte) ([]FailedTest, error) {
+	var root junitSuite
+	if err := xml.Unmarshal(body, &root); err != nil {
+		return nil, err
+	}
+
+	return junitFailures(job, root), nil
+}
+
+func junitFailures(job string, suite junitSuite) []FailedTest {
+	var tests []FailedTest
+	for _, tc := range suite.Cases {
+		failure := tc.Failure
+		if failure == nil {
+			failure = tc.Error
+		}
+
+		if failure == nil {
+			continue
+		}
+
+		message := failure.Message
+		if len(message) == 0 {
+			message = strings.TrimSpace(failure.Text)
+		}
+
+		// the first line is enough for a notification
+		if idx := strings.IndexByte(message, '\n'); idx >= 0 {
+			message = message[:idx]
+		}
+
+		suiteName := suite.Name
+		if len(suiteName) == 0 {
+			suiteName = tc.Class
+		}
+
+		tests = append(tests, FailedTest{
+			Job:     job,
+			Suite:   suiteName,
+			Name:    tc.Name,
+			Message: message,
+		})
+	}
+
+	for _, nested := range suite.Suites {
+		tests = append(tests, junitFailures(job, nested)...)
+	}
+
+	return tests
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestParseJUnit(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []FailedTest
+	}{
+		{
+			name: "nested suites",
+			body: `<?xml version="1.0"?>
+<testsuites>
+  <testsuite name="api/handlers">
+    <testcase classname="handlers" name="TestOk"/>
+    <testcase classname="handlers" name="TestFails"><failure message="expected 200, got 500">stack</failure></testcase>
+  </testsuite>
+  <testsuite name="api/store">
+    <testcase classname="store" name="TestPanics"><error>panic: nil map
+goroutine 1</error></testcase>
+  </testsuite>
+</testsuites>`,
+			expected: []FailedTest{
+				{Job: "test", Suite: "api/handlers", Name: "TestFails", Message: "expected 200, got 500"},
+				{Job: "test", Suite: "api/store", Name: "TestPanics", Message: "panic: nil map"},
+			},
+		},
+		{
+			name:     "single suite",
+			body:     `<testsuite><testcase classname="UserSpec" name="logs in"><failure message="timeout"/></testcase></testsuite>`,
+			expected: []FailedTest{{Job: "test", Suite: "UserSpec", Name: "logs in", Message: "timeout"}},
+		},
+		{
+			name: "all green",
+			body: `<testsuite name="s"><testcase name="a"/></testsuite>`,
+		},
+	}
+
+	for _, test := range tests {
+		failed, err := parseJUnit("test", []byte(test.body))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if len(failed) != len(test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, failed)
+			continue
+		}
+
+		for i := range failed {
+			if failed[i] != test.expected[i] {
+				t.Errorf("%s: expected %+v, got %+v", test.name, test.expected[i], failed[i])
+			}
+		}
+	}
+
+	if _, err := parseJUnit("test", []byte("not xml")); err == nil {
+		t.Error("expected an error for a broken report")
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"github.com/kudrykv/services-deploy-monitor/app/config"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Clock lets tests drive the monitors without waiting for real time.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func defaultSettings(cm config.Monitor) MonitorSettings {
+	return MonitorSettings{
+		PollInterval:    time.Duration(cm.PollTimeIntervalS) * time.Second,
+		PollMaxInterval: time.Duration(cm.PollMaxIntervalS) * time.Second,
+		BackoffPercent:  cm.PollBackoffPercent,
+		JitterPercent:   cm.PollJitterPercent,
+		AppearTimeout:   time.Duration(cm.BuildAppearTimeoutS) * time.Second,
+		GreenTimeout:    time.Duration(cm.GreenTimeoutS) * time.Second,
+		WebhookFallback: time.Duration(cm.WebhookFallbackS) * time.Second,
+		Provider:        cm.Provider,
+
+		ProgressNotifications: cm.ProgressNotifications,
+		FailedTests:           cm.FailedTestsMax,
+		LogTailLines:          cm.LogTailLines,
+		LogTailBytes:          cm.LogTailBytes,
+		Retries:               cm.BuildRetries,
+	}
+}
+
+// settingsFor applies every override matching the event on top of the defaults, in order,
+// so that the later and more specific ones win.
+func settingsFor(defaults MonitorSettings, overrides []MonitorOverride, event Event) MonitorSettings {
+	settings := defaults
+	for _, o := range overrides {
+		if o.matches(event) {
+			settings = settings.merge(o.Settings).clear(o.Cleared)
+		}
+	}
+
+	return settings
+}
+
+func (o MonitorOverride) matches(event Event) bool {
+	if len(o.Org) > 0 && !strings.EqualFold(o.Org, event.Org) {
+		return false
+	}
+
+	if o.Repo != nil && !o.Repo.MatchString(event.Repo) {
+		return false
+	}
+
+	if o.Branch != nil && !o.Branch.MatchString(event.BranchRef) {
+		return false
+	}
+
+	if o.Tag != nil && !o.Tag.MatchString(event.Tag) {
+		return false
+	}
+
+	return true
+}
+
+func (ms MonitorSettings) merge(o MonitorSettings) MonitorSettings {
+	if o.PollInterval > 0 {
+		ms.PollInterval = o.PollInterval
+	}
+
+	if o.PollMaxInterval > 0 {
+		ms.PollMaxInterval = o.PollMaxInterval
+	}
+
+	if o.BackoffPercent > 0 {
+		ms.BackoffPercent = o.BackoffPercent
+	}
+
+	if o.JitterPercent > 0 {
+		ms.JitterPercent = o.JitterPercent
+	}
+
+	if o.AppearTimeout > 0 {
+		ms.AppearTimeout = o.AppearTimeout
+	}
+
+	if o.GreenTimeout > 0 {
+		ms.GreenTimeout = o.GreenTimeout
+	}
+
+	if o.WebhookFallback > 0 {
+		ms.WebhookFallback = o.WebhookFallback
+	}
+
+	if len(o.Provider) > 0 {
+		ms.Provider = o.Provider
+	}
+
+	if o.RequiredJobs != nil {
+		ms.RequiredJobs = o.RequiredJobs
+	}
+
+	if o.OptionalJobs != nil {
+		ms.OptionalJobs = o.OptionalJobs
+	}
+
+	if o.ProgressNotifications {
+		ms.ProgressNotifications = true
+	}
+
+	if o.FailedTests > 0 {
+		ms.FailedTests = o.FailedTests
+	}
+
+	if o.LogTailLines > 0 {
+		ms.LogTailLines = o.LogTailLines
+	}
+
+	if o.LogTailBytes > 0 {
+		ms.LogTailBytes = o.LogTailBytes
+	}
+
+	if o.Retries > 0 {
+		ms.Retries = o.Retries
+	}
+
+	return ms
+}
+
+// clear turns off the named settings.
+func (ms MonitorSettings) clear(names []string) MonitorSettings {
+	for _, name := range names {
+		switch name {
+		case SettingJitterPercent:
+			ms.JitterPercent = 0
+		case SettingWebhookFallback:
+			ms.WebhookFallback = 0
+		case SettingProgressNotifications:
+			ms.ProgressNotifications = false
+		case SettingFailedTests:
+			ms.FailedTests = 0
+		case SettingLogTailLines:
+			ms.LogTailLines = 0
+		case SettingLogTailBytes:
+			ms.LogTailBytes = 0
+		case SettingRetries:
+			ms.Retries = 0
+		}
+	}
+
+	return ms
+}
+
+// backoff grows the interval by BackoffPercent, up to PollMaxInterval.
+func (ms MonitorSettings) backoff(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return ms.PollInterval
+	}
+
+	if ms.BackoffPercent > 100 {
+		interval = interval * time.Duration(ms.BackoffPercent) / 100
+	}
+
+	if ms.PollMaxInterval > 0 && interval > ms.PollMaxInterval {
+		interval = ms.PollMaxInterval
+	}
+
+	return interval
+}
+
+// jitter spreads the polls of monitors started together by up to JitterPercent in both directions.
+func (ms MonitorSettings) jitter(interval time.Duration) time.Duration {
+	if ms.JitterPercent <= 0 {
+		return interval
+	}
+
+	spread := float64(interval) * float64(ms.JitterPercent) / 100
+	return interval + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// selective reports whether some jobs do not count for the outcome.
+func (ms MonitorSettings) selective() bool {
+	return len(ms.RequiredJobs) > 0 || len(ms.OptionalJobs) > 0
+}
+
+// counts reports whether the job or workflow decides the outcome.
+// With required jobs listed only they count, otherwise everything but the optional ones.
+func (ms MonitorSettings) counts(name string) bool {
+	if len(ms.RequiredJobs) > 0 {
+		return Contains(ms.RequiredJobs, name)
+	}
+
+	return !Contains(ms.OptionalJobs, name)
+}
+
+func (ms MonitorSettings) relevant(builds []Build) []Build {
+	if !ms.selective() {
+		return builds
+	}
+
+	var ret []Build
+	for _, build := range builds {
+		if ms.counts(build.Name) {
+			ret = append(ret, build)
+		}
+	}
+
+	return ret
+}
+
+// missing lists the required jobs without a build yet.
+func (ms MonitorSettings) missing(builds []Build) []string {
+	var missing []string
+	for _, name := range ms.RequiredJobs {
+		found := false
+		for _, build := range builds {
+			found = found || build.Name == name
+		}
+
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing
+}
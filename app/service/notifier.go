@@ -5,93 +5,173 @@ import (
 	"context"
 	"fmt"
 	"github.com/Sirupsen/logrus"
+	"github.com/kudrykv/services-deploy-monitor/app/internal/httputil"
 	"github.com/kudrykv/services-deploy-monitor/app/internal/logging"
+	"sort"
+	"strings"
 )
 
 type notifier struct {
-	cfg Config
+	routing Routing
+	queue   DeliveryQueue
 }
 
-func New(cfg Config) Notifier {
+func New(routing Routing, queue DeliveryQueue) Notifier {
 	return &notifier{
-		cfg: cfg,
+		routing: routing,
+		queue:   queue,
 	}
 }
 
 func (s *notifier) Do(ctx context.Context, notification Event) {
-	switch notification.Event {
-	case "pull_request_merged":
-		switch notification.Source {
-		case sourceGithub:
-			systems := findSystems(s.cfg.Cvs, notification.BranchRef, notification.Tag)
-
-			if systems == nil {
-				logging.WithFields(logrus.Fields{"notification": notification}).Info("skip systems github")
-				return
-			}
-
-			sendPack, ok := systems.Github[notification.Event]
-			if !ok {
-				logging.WithFields(logrus.Fields{"notification": notification}).Warn("no action defined for event")
-				return
-			}
-
-			fmt.Println("inside", sendPack.Message)
-			buff := bytes.NewBuffer(nil)
-			if err := sendPack.Message.Execute(buff, notification); err != nil {
-				logging.WithFields(logrus.Fields{"notification": notification, "err": err}).Error("execute")
-				return
-			}
-
-		case sourceCircleCi:
-			systems := findSystems(s.cfg.Cvs, notification.BranchRef, notification.Tag)
-
-			if systems == nil {
-				logging.WithFields(logrus.Fields{"notification": notification}).Info("skip systems circleci")
-				return
-			}
-
-			eventer, ok := systems.CircleCi[notification.Event]
-			if !ok {
-				logging.WithFields(logrus.Fields{"notification": notification}).Warn("no action defined for event")
-				return
-			}
-
-			sendPack, ok := eventer[notification.BuildStatus]
-			if !ok {
-				logging.WithFields(logrus.Fields{"notification": notification}).Warn("no action defined for event")
-				return
-			}
-
-			buff := bytes.NewBuffer(nil)
-			if err := sendPack.Message.Execute(buff, notification); err != nil {
-				logging.WithFields(logrus.Fields{"notification": notification, "err": err}).Error("execute")
-				return
-			}
-
-		default:
-			logging.WithFields(logrus.Fields{"notification": notification}).Error("unknown source")
+	fields := logrus.Fields{
+		"request_id":   httputil.GetRequestId(ctx),
+		"notification": notification,
+	}
+
+	if !Contains(NotifiedEvents, notification.Event) {
+		logging.WithFields(fields).Error("unknown event")
+		return
+	}
+
+	if len(notification.Source) == 0 {
+		logging.WithFields(fields).Error("unknown source")
+		return
+	}
+
+	cfg := s.routing.Config()
+	systems := findSystems(cfg.Cvs, notification)
+	if len(systems) == 0 {
+		logging.WithFields(fields).Info("skip systems " + notification.Source)
+		return
+	}
+
+	sent := 0
+	for _, ss := range systems {
+		sendPack, ok := pickSendPack(ss, notification)
+		if !ok {
+			continue
+		}
+
+		msg, err := render(sendPack, notification)
+		if err != nil {
+			logging.WithFields(fields).WithFields(logrus.Fields{"room": sendPack.Room, "err": err}).Error("render notification")
+			continue
+		}
+
+		msg.slack = cfg.Slacks[msg.Slack]
+		s.queue.Enqueue(ctx, msg)
+		sent += 1
+	}
+
+	if sent == 0 {
+		logging.WithFields(fields).Warn("no action defined for event")
+	}
+}
+
+func pickSendPack(ss Systems, notification Event) (SendPack, bool) {
+	if notification.Source == sourceGithub {
+		sendPack, ok := ss.Github[notification.Event]
+		return sendPack, ok
+	}
+
+	// any other source is a ci provider. The circleci section predates the others
+	// and serves the providers without their own one.
+	events, ok := ss.Ci[notification.Source]
+	if !ok {
+		events = ss.Ci[ProviderCircleCi]
+	}
+
+	sendPack, ok := events[notification.Event][notification.BuildStatus]
+	return sendPack, ok
+}
+
+func render(sendPack SendPack, notification Event) (Outgoing, error) {
+	buff := bytes.NewBuffer(nil)
+	if err := sendPack.Message.Execute(buff, notification); err != nil {
+		return Outgoing{}, fmt.Errorf("execute template %s: %v", sendPack.Message.Name(), err)
+	}
+
+	return Outgoing{
+		Slack: sendPack.Slack,
+		Room:  sendPack.Room,
+		Text:  buff.String(),
+	}, nil
+}
+
+// findSystems collects systems of the matching rules in order, until a matching rule
+// without Continue is found. A rule without a template for the notification does not
+// stop the chain, the rules below it still get their turn.
+func findSystems(cvs Cvs, notification Event) []Systems {
+	var found []Systems
+
+	for _, rule := range cvs.Rules {
+		if !rule.matches(notification) {
+			continue
+		}
+
+		found = append(found, rule.Systems)
+
+		if _, ok := pickSendPack(rule.Systems, notification); ok && !rule.Continue {
+			break
 		}
+	}
+
+	return found
+}
+
+// SortRules orders rules by priority, highest first. Rules with equal priority keep their order.
+func SortRules(rules []Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority > rules[j].Priority
+	})
+}
 
-	default:
-		logging.WithFields(logrus.Fields{"notification": notification}).Error("unknown event")
+func (r Rule) matches(notification Event) bool {
+	if len(r.Org) == 0 && r.Repo == nil && len(r.Repos) == 0 && r.Branch == nil && r.Tag == nil {
+		return false
 	}
 
-	fmt.Println("notification", notification)
+	if len(r.Org) > 0 && !strings.EqualFold(r.Org, notification.Org) {
+		return false
+	}
+
+	if r.Repo != nil && !r.Repo.MatchString(notification.Repo) {
+		return false
+	}
+
+	if len(r.Repos) > 0 && !containsFold(r.Repos, notification.Repo) {
+		return false
+	}
+
+	if r.Branch != nil && !r.Branch.MatchString(notification.BranchRef) {
+		return false
+	}
+
+	if r.Tag != nil && (len(notification.Tag) == 0 || !r.Tag.MatchString(notification.Tag)) {
+		return false
+	}
+
+	return true
 }
 
-func findSystems(cvs Cvs, branch, tag string) *Systems {
-	for rxp, s := range cvs.Branches {
-		if rxp.MatchString(branch) {
-			return &s
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
 		}
 	}
 
-	for rxp, s := range cvs.Tags {
-		if rxp.MatchString(tag) {
-			return &s
+	return false
+}
+
+// Contains reports whether the list has the value.
+func Contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
 		}
 	}
 
-	return nil
+	return false
 }
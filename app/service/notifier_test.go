@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"text/template"
+)
+
+type recordingQueue struct {
+	DeliveryQueue
+	messages []Outgoing
+}
+
+func (q *recordingQueue) Enqueue(ctx context.Context, msg Outgoing) {
+	q.messages = append(q.messages, msg)
+}
+
+func pack(room, text string) SendPack {
+	return SendPack{Message: template.Must(template.New(room).Parse(text)), Slack: "team", Room: room}
+}
+
+func TestNotifierDelivers(t *testing.T) {
+	cfg := Config{
+		Cvs: Cvs{
+			Rules: []Rule{{
+				Branch: regexp.MustCompile("^master$"),
+				Systems: Systems{
+					Github: map[string]SendPack{
+						PullRequestMergedEvent: pack("deploys", "{{.Repo}} #{{.PrNumber}} merged"),
+					},
+					Ci: map[string]map[string]map[string]SendPack{
+						ProviderCircleCi: {
+							PullRequestMergedEvent: {"success": pack("deploys", "{{.Repo}} is {{.BuildStatus}}")},
+						},
+					},
+				},
+			}},
+		},
+	}
+
+	queue := &recordingQueue{}
+	n := New(NewRouting(cfg), queue)
+	n.Do(context.Background(), Event{
+		Event:     PullRequestMergedEvent,
+		Source:    sourceGithub,
+		Repo:      "api",
+		BranchRef: "master",
+		PrNumber:  42,
+	})
+	n.Do(context.Background(), Event{
+		Event:       PullRequestMergedEvent,
+		Source:      ProviderCircleCi,
+		Repo:        "api",
+		BranchRef:   "master",
+		BuildStatus: "success",
+	})
+	n.Do(context.Background(), Event{
+		Event:     PullRequestMergedEvent,
+		Source:    sourceGithub,
+		Repo:      "api",
+		BranchRef: "feature",
+	})
+
+	if len(queue.messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(queue.messages))
+	}
+
+	msg := queue.messages[0]
+	if msg.Text != "api #42 merged" || msg.Slack != "team" || msg.Room != "deploys" {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+
+	if queue.messages[1].Text != "api is success" {
+		t.Errorf("unexpected message: %+v", queue.messages[1])
+	}
+}
+
+func TestNotifierRoutesReleaseByTag(t *testing.T) {
+	cfg := Config{
+		Cvs: Cvs{
+			Rules: []Rule{
+				{Branch: regexp.MustCompile("^master$"), Systems: Systems{Github: map[string]SendPack{ReleaseEvent: pack("dev", "dev")}}},
+				{Tag: regexp.MustCompile("^release-"), Priority: 1, Systems: Systems{Github: map[string]SendPack{ReleaseEvent: pack("prod", "{{.Tag}} {{.ReleaseUrl}}")}}},
+			},
+		},
+	}
+	SortRules(cfg.Cvs.Rules)
+
+	queue := &recordingQueue{}
+	New(NewRouting(cfg), queue).Do(context.Background(), Event{
+		Event:      ReleaseEvent,
+		Source:     sourceGithub,
+		BranchRef:  "master",
+		Tag:        "release-2018W23-1.0",
+		ReleaseUrl: "https://github.com/org/api/releases/1",
+	})
+
+	if len(queue.messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(queue.messages))
+	}
+
+	if text := queue.messages[0].Text; text != "release-2018W23-1.0 https://github.com/org/api/releases/1" {
+		t.Errorf("unexpected text: %s", text)
+	}
+}
+
+func TestNotifierFansOutByPriority(t *testing.T) {
+	rule := func(ptn string, priority int, cont bool, room string) Rule {
+		return Rule{
+			Branch:   regexp.MustCompile(ptn),
+			Priority: priority,
+			Continue: cont,
+			Systems:  Systems{Github: map[string]SendPack{PullRequestMergedEvent: pack(room, room)}},
+		}
+	}
+
+	rules := []Rule{
+		rule(".*", 0, false, "all"),
+		rule("^master$", 10, true, "master"),
+		rule("^mas", 5, false, "mas"),
+		rule("^master$", 5, false, "never"),
+	}
+	SortRules(rules)
+
+	for i := 0; i < 10; i++ {
+		queue := &recordingQueue{}
+		New(NewRouting(Config{Cvs: Cvs{Rules: rules}}), queue).Do(context.Background(), Event{
+			Event:     PullRequestMergedEvent,
+			Source:    sourceGithub,
+			BranchRef: "master",
+		})
+
+		if len(queue.messages) != 2 || queue.messages[0].Room != "master" || queue.messages[1].Room != "mas" {
+			t.Fatalf("unexpected messages: %+v", queue.messages)
+		}
+	}
+}
+
+func TestNotifierRoutesByRepo(t *testing.T) {
+	rules := []Rule{
+		{
+			Org:      "fubotv",
+			Repos:    []string{"payments", "billing"},
+			Branch:   regexp.MustCompile("^master$"),
+			Priority: 10,
+			Systems:  Systems{Github: map[string]SendPack{PullRequestMergedEvent: pack("payments-deploys", "")}},
+		},
+		{
+			Repo:    regexp.MustCompile("^web-"),
+			Systems: Systems{Github: map[string]SendPack{PullRequestMergedEvent: pack("web-deploys", "")}},
+		},
+		{
+			Branch:  regexp.MustCompile("^master$"),
+			Systems: Systems{Github: map[string]SendPack{PullRequestMergedEvent: pack("deploys", "")}},
+		},
+	}
+	SortRules(rules)
+
+	cases := map[string]Event{
+		"payments-deploys": {Org: "FuboTV", Repo: "billing", BranchRef: "master"},
+		"web-deploys":      {Org: "fubotv", Repo: "web-player", BranchRef: "master"},
+		"deploys":          {Org: "other", Repo: "payments", BranchRef: "master"},
+	}
+
+	for room, event := range cases {
+		event.Event = PullRequestMergedEvent
+		event.Source = sourceGithub
+
+		queue := &recordingQueue{}
+		New(NewRouting(Config{Cvs: Cvs{Rules: rules}}), queue).Do(context.Background(), event)
+
+		if len(queue.messages) != 1 || queue.messages[0].Room != room {
+			t.Errorf("%s: unexpected messages: %+v", room, queue.messages)
+		}
+	}
+}
+
+func TestNotifierFallsBackToCircleCiSection(t *testing.T) {
+	cfg := Config{
+		Cvs: Cvs{
+			Rules: []Rule{{
+				Branch: regexp.MustCompile("^master$"),
+				Systems: Systems{
+					Ci: map[string]map[string]map[string]SendPack{
+						ProviderCircleCi: {
+							PullRequestMergedEvent: {"success": pack("deploys", "{{.Repo}} built by {{.Source}}")},
+						},
+						"jenkins": {},
+					},
+				},
+			}},
+		},
+	}
+
+	queue := &recordingQueue{}
+	n := New(NewRouting(cfg), queue)
+	for _, source := range []string{ProviderGithubActions, "jenkins"} {
+		n.Do(context.Background(), Event{
+			Event:       PullRequestMergedEvent,
+			Source:      source,
+			Repo:        "web",
+			BranchRef:   "master",
+			BuildStatus: BuildStatusSuccess,
+		})
+	}
+
+	if len(queue.messages) != 1 || queue.messages[0].Text != "web built by github_actions" {
+		t.Errorf("unexpected messages: %+v", queue.messages)
+	}
+}
+
+func TestNotifierSkipsRulesWithoutTemplate(t *testing.T) {
+	rules := []Rule{
+		{
+			Branch:   regexp.MustCompile("^master$"),
+			Priority: 10,
+			Systems:  Systems{Github: map[string]SendPack{ReleaseEvent: pack("releases", "")}},
+		},
+		{
+			Branch:  regexp.MustCompile("^master$"),
+			Systems: Systems{Github: map[string]SendPack{PullRequestMergedEvent: pack("deploys", "")}},
+		},
+	}
+	SortRules(rules)
+
+	queue := &recordingQueue{}
+	New(NewRouting(Config{Cvs: Cvs{Rules: rules}}), queue).Do(context.Background(), Event{
+		Event:     PullRequestMergedEvent,
+		Source:    sourceGithub,
+		BranchRef: "master",
+	})
+
+	if len(queue.messages) != 1 || queue.messages[0].Room != "deploys" {
+		t.Errorf("unexpected messages: %+v", queue.messages)
+	}
+}
+
+func TestNotifierBindsSlackOfItsConfig(t *testing.T) {
+	old := NewSlack("https://hooks.slack.com/old")
+	routing := NewRouting(Config{
+		Cvs: Cvs{Rules: []Rule{{
+			Branch:  regexp.MustCompile("^master$"),
+			Systems: Systems{Github: map[string]SendPack{PullRequestMergedEvent: pack("deploys", "")}},
+		}}},
+		Slacks: map[string]Slack{"team": old},
+	})
+
+	queue := &recordingQueue{}
+	New(routing, queue).Do(context.Background(), Event{Event: PullRequestMergedEvent, Source: sourceGithub, BranchRef: "master"})
+
+	routing.Set(Config{Slacks: map[string]Slack{"team": NewSlack("https://hooks.slack.com/new")}})
+
+	if len(queue.messages) != 1 || queue.messages[0].slack != old {
+		t.Errorf("expected the message bound to the slack it was rendered for, got %+v", queue.messages)
+	}
+}
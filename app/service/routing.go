@@ -0,0 +1,27 @@
+package service
+
+import (
+	"sync/atomic"
+)
+
+type routing struct {
+	current atomic.Value
+}
+
+// NewRouting keeps the config in one snapshot, so that a reload swaps the rules
+// and the slacks at once and no notification sees half of each.
+func NewRouting(cfg Config) Routing {
+	r := &routing{}
+	r.current.Store(cfg)
+
+	return r
+}
+
+func (s *routing) Config() Config {
+	return s.current.Load().(Config)
+}
+
+// Set swaps the config. Notifications being processed finish with the old one.
+func (s *routing) Set(cfg Config) {
+	s.current.Store(cfg)
+}
@@ -2,8 +2,9 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -41,7 +42,7 @@ type message struct {
 	Channel *string `json:"channel,omitempty"`
 }
 
-func (s *slack) SendMessage(channel, text string) error {
+func (s *slack) SendMessage(ctx context.Context, channel, text string) error {
 	msg := message{Text: text}
 	if len(channel) > 0 {
 		msg.Channel = &channel
@@ -57,18 +58,22 @@ func (s *slack) SendMessage(channel, text string) error {
 		return err
 	}
 
-	resp, err := s.client.Do(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
 
+	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
 		b, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
 		}
 
-		return errors.New(string(b))
+		return fmt.Errorf("slack responded with %d: %s", resp.StatusCode, string(b))
 	}
 
 	return nil
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type slackStandIn struct {
+	mu       sync.Mutex
+	status   int
+	body     string
+	messages []message
+}
+
+func (si *slackStandIn) received() []message {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	return append([]message{}, si.messages...)
+}
+
+func (si *slackStandIn) respondWith(status int) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	si.status = status
+}
+
+func newSlackStandIn(t *testing.T, status int, body string) (*slackStandIn, *httptest.Server) {
+	si := &slackStandIn{status: status, body: body}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+
+		var msg message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("decode message: %v", err)
+		}
+
+		si.mu.Lock()
+		si.messages = append(si.messages, msg)
+		status := si.status
+		si.mu.Unlock()
+
+		w.WriteHeader(status)
+		w.Write([]byte(si.body))
+	}))
+
+	return si, ts
+}
+
+func TestSlackSendMessage(t *testing.T) {
+	si, ts := newSlackStandIn(t, http.StatusOK, "ok")
+	defer ts.Close()
+
+	if err := NewSlack(ts.URL).SendMessage(context.Background(), "bot-test", "hello"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	messages := si.received()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+
+	msg := messages[0]
+	if msg.Text != "hello" || msg.Channel == nil || *msg.Channel != "bot-test" {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+}
+
+func TestSlackSendMessageWithoutChannel(t *testing.T) {
+	si, ts := newSlackStandIn(t, http.StatusOK, "ok")
+	defer ts.Close()
+
+	if err := NewSlack(ts.URL).SendMessage(context.Background(), "", "hello"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if msg := si.received()[0]; msg.Channel != nil {
+		t.Errorf("expected no channel, got %s", *msg.Channel)
+	}
+}
+
+func TestSlackSendMessageError(t *testing.T) {
+	_, ts := newSlackStandIn(t, http.StatusNotFound, "channel_not_found")
+	defer ts.Close()
+
+	err := NewSlack(ts.URL).SendMessage(context.Background(), "nope", "hello")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("error lacks context: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/google/go-github/github"
 	"regexp"
 	"text/template"
+	"time"
 )
 
 type AggregatedWebhook struct {
@@ -12,6 +13,44 @@ type AggregatedWebhook struct {
 	PullRequestEvent *github.PullRequestEvent
 	ReleaseEvent     *github.ReleaseEvent
 	CreateEvent      *github.CreateEvent
+	WorkflowRunEvent *WorkflowRunPayload
+	CheckSuiteEvent  *CheckSuitePayload
+}
+
+// WorkflowRunPayload is the workflow_run webhook. The vendored go-github predates github actions.
+type WorkflowRunPayload struct {
+	Action      string             `json:"action"`
+	WorkflowRun WorkflowRun        `json:"workflow_run"`
+	Repo        *github.Repository `json:"repository"`
+}
+
+type WorkflowRun struct {
+	Id           int64      `json:"id"`
+	Name         string     `json:"name"`
+	HeadSha      string     `json:"head_sha"`
+	HeadBranch   string     `json:"head_branch"`
+	Status       string     `json:"status"`
+	Conclusion   string     `json:"conclusion"`
+	HtmlUrl      string     `json:"html_url"`
+	RunStartedAt *time.Time `json:"run_started_at"`
+	UpdatedAt    *time.Time `json:"updated_at"`
+}
+
+type CheckSuitePayload struct {
+	Action     string             `json:"action"`
+	CheckSuite CheckSuite         `json:"check_suite"`
+	Repo       *github.Repository `json:"repository"`
+}
+
+type CheckSuite struct {
+	Id         int64  `json:"id"`
+	HeadSha    string `json:"head_sha"`
+	HeadBranch string `json:"head_branch"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+	App        struct {
+		Slug string `json:"slug"`
+	} `json:"app"`
 }
 
 type Event struct {
@@ -26,25 +65,193 @@ type Event struct {
 	RefType     string
 	PrTitle     string
 	PrNumber    int
+	ReleaseName string
+	ReleaseUrl  string
 	BuildStatus string
+	// SupersededBy is the commit which took over the branch when BuildStatus is superseded.
+	SupersededBy string
+	// FailedJobs names the jobs which failed the build when BuildStatus is build_failed.
+	FailedJobs []string
+	// Jobs lists every job of the build as seen on the last poll.
+	Jobs []JobResult
+	// FailedTests and LogTail tell why the build failed, when the ci can tell.
+	// MoreFailedTests counts the failed tests which did not fit into the limit.
+	FailedTests     []FailedTest
+	MoreFailedTests int
+	LogTail         string
+	// Retries counts how many times the failed builds were retried, a success with retries passed on retry.
+	Retries int
+}
+
+type JobResult struct {
+	Name      string
+	Status    string
+	Url       string
+	StartedAt *time.Time
+	StoppedAt *time.Time
+	// Duration runs up to now for the jobs which have not finished.
+	Duration time.Duration
+}
+
+// MonitorState is what a monitor needs to carry on after a restart.
+type MonitorState struct {
+	Id        string `json:"id"`
+	RequestId string `json:"request_id"`
+	Event     Event  `json:"event"`
+	Provider  string `json:"provider"`
+	Phase     string `json:"phase"`
+	Skips     int    `json:"skips"`
+	Greens    bool   `json:"greens"`
+	Restarts  int    `json:"restarts"`
+	OnHold    bool   `json:"on_hold"`
+	// DoneJobs names the green jobs already reported by a progress notification.
+	DoneJobs []string `json:"done_jobs"`
+	// Retries counts the rounds of retried failed builds, Retried numbers the builds replaced by the retries.
+	Retries int   `json:"retries"`
+	Retried []int `json:"retried"`
+	// RetryRefused is set once the ci refused to rerun a failed build, no later round can turn it green.
+	RetryRefused bool      `json:"retry_refused"`
+	StartedAt    time.Time `json:"started_at"`
+	// CommittedAt bounds how far back the ci builds are looked up, zero when unknown.
+	CommittedAt time.Time `json:"committed_at"`
+	// Deadline is the end of the current phase: searching for the build or waiting for it to go green.
+	Deadline     time.Time     `json:"deadline"`
+	PollInterval time.Duration `json:"poll_interval"`
+	// LastCiStatus lists the statuses of the matching builds seen on the last poll.
+	LastCiStatus string    `json:"last_ci_status"`
+	PolledAt     time.Time `json:"polled_at"`
 }
 
 type Config struct {
-	Cvs Cvs
+	Cvs      Cvs
+	Monitors []MonitorOverride
+	// Slacks are the workspaces the rules send to, keyed by the name used in SendPack.
+	Slacks map[string]Slack
+}
+
+// MonitorOverride changes the monitor settings for the matching repos and branches.
+type MonitorOverride struct {
+	Org      string
+	Repo     *regexp.Regexp
+	Branch   *regexp.Regexp
+	Tag      *regexp.Regexp
+	Settings MonitorSettings
+	// Cleared lists the Setting* the override turns off, as zero fields of Settings keep the earlier values.
+	Cleared []string
+}
+
+// MonitorSettings control the polling of one monitor. Zero fields of an override keep the earlier values.
+type MonitorSettings struct {
+	PollInterval    time.Duration
+	PollMaxInterval time.Duration
+	BackoffPercent  int
+	JitterPercent   int
+	AppearTimeout   time.Duration
+	GreenTimeout    time.Duration
+	WebhookFallback time.Duration
+	Provider        string
+	// RequiredJobs and OptionalJobs name the jobs or workflows which must pass and which are ignored.
+	RequiredJobs []string
+	OptionalJobs []string
+	// ProgressNotifications adds started and progress notifications to the final one.
+	ProgressNotifications bool
+	// FailedTests, LogTailLines and LogTailBytes limit the failure details of build_failed.
+	FailedTests  int
+	LogTailLines int
+	LogTailBytes int
+	// Retries is how many times the failed builds get retried before the failure is reported.
+	Retries int
+}
+
+type FailedTest struct {
+	Job     string
+	Suite   string
+	Name    string
+	Message string
+}
+
+// Failure is what the ci tells about a failed build: the failed tests and the output of the failed step.
+type Failure struct {
+	Tests []FailedTest
+	Log   string
+}
+
+// Build is one build of a commit as any ci provider reports it. Status is one of the Ci* states.
+type Build struct {
+	Provider  string
+	Name      string
+	Status    string
+	Url       string
+	Sha       string
+	Branch    string
+	Tag       string
+	StartedAt *time.Time
+	StoppedAt *time.Time
+	// Number identifies the build in the ci, zero when the ci has no numbers.
+	Number int
+	// Id identifies the build in the ci which has no numbers, like a workflow of the circleci v2 api.
+	Id string
+}
+
+// CiResult is a finished workflow or job pushed by the ci webhook.
+type CiResult struct {
+	Id       string
+	Provider string
+	Kind     string
+	Org      string
+	Repo     string
+	Branch   string
+	Sha      string
+	Tag      string
+	Name     string
+	Status   string
+	Url      string
 }
 
 type Cvs struct {
-	Branches map[*regexp.Regexp]Systems
-	Tags     map[*regexp.Regexp]Systems
+	// Rules are checked in order, see SortRules.
+	Rules []Rule
+}
+
+// Rule matches an event when every condition it has is met.
+type Rule struct {
+	Name     string
+	Priority int
+	Org      string
+	Repo     *regexp.Regexp
+	Repos    []string
+	Branch   *regexp.Regexp
+	Tag      *regexp.Regexp
+	// Continue lets the lower rules match the same event and notify their channels too.
+	Continue bool
+	Systems  Systems
 }
 
 type Systems struct {
-	Github   map[string]SendPack
-	CircleCi map[string]map[string]SendPack
+	Github map[string]SendPack
+	// Ci holds the send packs by ci provider, event and build status.
+	Ci map[string]map[string]map[string]SendPack
 }
 
 type SendPack struct {
 	Message *template.Template
-	Slack   Slack
-	Room    string
+	// Slack is the name of the workspace from slack-config.json.
+	Slack string
+	Room  string
+}
+
+// Outgoing is a rendered message waiting for delivery, or a dead letter once delivery gave up.
+type Outgoing struct {
+	Id        string     `json:"id"`
+	RequestId string     `json:"request_id"`
+	Slack     string     `json:"slack"`
+	Room      string     `json:"room"`
+	Text      string     `json:"text"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	FailedAt  *time.Time `json:"failed_at,omitempty"`
+
+	// slack is the workspace of the config the message was rendered with.
+	// Dead letters lose it and look the workspace up by name again.
+	slack Slack
 }
@@ -1,15 +1,59 @@
 package main
 
 type jsonConfig struct {
-	Cvs JsonCvs `json:"cvs"`
+	Cvs      JsonCvs       `json:"cvs"`
+	Monitors []JsonMonitor `json:"monitors"`
+}
+
+// JsonMonitor overrides the monitor settings from the env for the matching repos and branches.
+// Every matching override applies, in order. The pointer fields can be set to 0 or false
+// to turn off what the env or an earlier override turned on.
+type JsonMonitor struct {
+	Org              string   `json:"org"`
+	Repo             string   `json:"repo"`
+	Branch           string   `json:"branch"`
+	Tag              string   `json:"tag"`
+	PollIntervalS    int      `json:"poll_interval_s"`
+	PollMaxIntervalS int      `json:"poll_max_interval_s"`
+	BackoffPercent   int      `json:"backoff_percent"`
+	JitterPercent    *int     `json:"jitter_percent"`
+	AppearTimeoutS   int      `json:"appear_timeout_s"`
+	GreenTimeoutS    int      `json:"green_timeout_s"`
+	WebhookFallbackS *int     `json:"webhook_fallback_s"`
+	Provider         string   `json:"provider"`
+	RequiredJobs     []string `json:"required_jobs"`
+	OptionalJobs     []string `json:"optional_jobs"`
+	// ProgressNotifications opts the repo in for the started and progress notifications.
+	ProgressNotifications *bool `json:"progress_notifications"`
+	FailedTestsMax        *int  `json:"failed_tests_max"`
+	LogTailLines          *int  `json:"log_tail_lines"`
+	LogTailBytes          *int  `json:"log_tail_bytes"`
+	Retries               *int  `json:"retries"`
 }
 
 type JsonCvs struct {
+	Rules    []JsonCvsRule          `json:"rules"`
 	Branches map[string]JsonCvsItem `json:"branches"`
+	Tags     map[string]JsonCvsItem `json:"tags"`
+}
+
+type JsonCvsRule struct {
+	Name     string   `json:"name"`
+	Priority int      `json:"priority"`
+	Org      string   `json:"org"`
+	Repo     string   `json:"repo"`
+	Repos    []string `json:"repos"`
+	Branch   string   `json:"branch"`
+	Tag      string   `json:"tag"`
+	Continue bool     `json:"continue"`
+	JsonCvsItem
 }
 
 type JsonCvsItem struct {
-	Github   map[string]JsonSystems            `json:"github"`
+	Github map[string]JsonSystems `json:"github"`
+	// Ci holds the send packs by ci provider, event and build status.
+	Ci map[string]map[string]map[string]JsonSystems `json:"ci"`
+	// CircleCi is the old name of ci.circleci.
 	CircleCi map[string]map[string]JsonSystems `json:"circle_ci"`
 }
 
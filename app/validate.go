@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kudrykv/services-deploy-monitor/app/service"
+	"io"
+)
+
+const (
+	patternsPath = "./send-patterns.json"
+	slackPath    = "./slack-config.json"
+)
+
+// validate runs the `validate` subcommand and returns the exit code.
+func validate(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	patterns := fs.String("patterns", patternsPath, "path to send-patterns.json")
+	slack := fs.String("slack", slackPath, "path to slack-config.json")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if _, err := loadConfig(*patterns, *slack); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	fmt.Fprintln(out, "OK")
+	return 0
+}
+
+// loadConfig parses both config files and reports the problems of both at once.
+func loadConfig(patterns, slack string) (service.Config, error) {
+	var errs ConfigErrors
+
+	slacks, err := ParseSlack(slack)
+	if err != nil {
+		if ce, ok := err.(ConfigErrors); ok {
+			errs = append(errs, ce...)
+		} else {
+			return service.Config{}, err
+		}
+	}
+
+	cfg, err := ParseConfig(patterns, slacks)
+	if err != nil {
+		if ce, ok := err.(ConfigErrors); ok {
+			errs = append(errs, ce...)
+		} else {
+			return service.Config{}, err
+		}
+	}
+
+	return cfg, errs.errOrNil()
+}